@@ -7,10 +7,19 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"mime"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"connectrpc.com/connect"
@@ -19,8 +28,10 @@ import (
 	"github.com/jhump/protoreflect/desc"
 	"github.com/jhump/protoreflect/desc/protoparse"
 	"github.com/mstoykov/k6-taskqueue-lib/taskqueue"
+	"github.com/sirupsen/logrus"
 	"go.k6.io/k6/js/common"
 	"go.k6.io/k6/js/modules"
+	"go.k6.io/k6/lib/fsext"
 	"go.k6.io/k6/lib/types"
 	"go.k6.io/k6/metrics"
 	"golang.org/x/net/http2"
@@ -30,37 +41,102 @@ import (
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protodesc"
 	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
 	"google.golang.org/protobuf/types/descriptorpb"
 	"google.golang.org/protobuf/types/dynamicpb"
 )
 
 type methodInfo struct {
-	Package         string
-	Service         string
-	FullMethod      string
-	grpc.MethodInfo `json:"-" js:"-"`
+	Package          string
+	Service          string
+	FullMethod       string
+	IdempotencyLevel string
+	Idempotent       bool
+	InputType        string
+	OutputType       string
+	grpc.MethodInfo  `json:"-" js:"-"`
 }
 
 type client struct {
 	vu      modules.VU
 	metrics *instanceMetrics
 
+	// params is given to the constructor and provides the defaults of load and connect.
+	params clientParams
+
+	// sharedHTTPClient is provided by the program embedding the module and replaces the built transport.
+	sharedHTTPClient *http.Client
+	// defaultTimeout is shared by the clients of the module instance and set by setDefaultTimeout.
+	defaultTimeout *time.Duration
+	// initEnv is the init environment the client is constructed in, whose file system resolves the TLS files of connect.
+	initEnv *common.InitEnvironment
+
 	// load
-	mds map[string]protoreflect.MethodDescriptor
+	mds             map[string]protoreflect.MethodDescriptor
+	files           *descriptorpb.FileDescriptorSet
+	reflectionCache map[string]*descriptorpb.FileDescriptorSet
+	// registry is built from files, and read by the calls decoding error details off the event loop.
+	registry atomic.Pointer[protoregistry.Files]
 
 	// connect
-	addr       *url.URL
-	httpClient *http.Client
+	addr            *url.URL
+	httpClient      *http.Client
+	protocol        string
+	reflectProtocol string
+	compression     string
+	gzipLevel       int
+	tlsConfig       *tls.Config
+	keepAlive       *keepAliveParams
+	codecName       string
+	contentSubtype  string
+	deterministic   bool
+	userAgent       string
+	timeout         time.Duration
+	maxTimeout      time.Duration
+	dialTimeout     time.Duration
+
+	// tags are added to the metrics of every call and stream, under the tags of the call.
+	tags map[string]string
+
+	// call
+	requestInterceptor sobek.Callable
+	metadataProvider   sobek.Callable
+
+	// streams are the open streams, which are canceled on close.
+	streamsMu sync.Mutex
+	streams   map[openStream]struct{}
+}
+
+// openStream is a server or client streaming call, which is canceled by close.
+type openStream interface {
+	close()
 }
 
 func newClient(vu modules.VU, metrics *instanceMetrics) *client {
 	return &client{
-		vu:      vu,
-		metrics: metrics,
-		mds:     make(map[string]protoreflect.MethodDescriptor),
+		vu:              vu,
+		metrics:         metrics,
+		initEnv:         vu.InitEnv(),
+		params:          defaultClientParams(),
+		mds:             make(map[string]protoreflect.MethodDescriptor),
+		files:           &descriptorpb.FileDescriptorSet{},
+		reflectionCache: make(map[string]*descriptorpb.FileDescriptorSet),
+		streams:         make(map[openStream]struct{}),
 	}
 }
 
+func (c *client) trackStream(s openStream) {
+	c.streamsMu.Lock()
+	defer c.streamsMu.Unlock()
+	c.streams[s] = struct{}{}
+}
+
+func (c *client) untrackStream(s openStream) {
+	c.streamsMu.Lock()
+	defer c.streamsMu.Unlock()
+	delete(c.streams, s)
+}
+
 func (c *client) Load(importPaths []string, filenames ...string) ([]methodInfo, error) {
 	if state := c.vu.State(); state != nil {
 		return nil, errors.New("load must be called in the init context")
@@ -71,8 +147,16 @@ func (c *client) Load(importPaths []string, filenames ...string) ([]methodInfo,
 		return nil, errors.New("missing init environment")
 	}
 
+	// the import paths of the client follow the ones given to the call
+	importPaths = append(importPaths[:len(importPaths):len(importPaths)], c.params.importPaths...)
 	if len(importPaths) == 0 {
-		importPaths = append(importPaths, initEnv.CWD.Path)
+		importPath := initEnv.CWD.Path
+		if c.params.importBase == importBaseScript {
+			if dir, ok := scriptDir(c.vu.Runtime()); ok {
+				importPath = dir
+			}
+		}
+		importPaths = append(importPaths, importPath)
 	}
 
 	parser := protoparse.Parser{
@@ -88,12 +172,140 @@ func (c *client) Load(importPaths []string, filenames ...string) ([]methodInfo,
 	if err != nil {
 		return nil, err
 	}
+	return c.registerFileDescriptors(fds)
+}
+
+// scriptDir returns the directory of the script file calling the function.
+func scriptDir(rt *sobek.Runtime) (string, bool) {
+	for _, frame := range rt.CaptureCallStack(0, nil) {
+		u, err := url.Parse(frame.SrcName())
+		if err != nil || u.Scheme != "file" {
+			continue
+		}
+		return path.Dir(u.Path), true
+	}
+	return "", false
+}
+
+func (c *client) LoadFromString(name string, content string) ([]methodInfo, error) {
+	if state := c.vu.State(); state != nil {
+		return nil, errors.New("load must be called in the init context")
+	}
+
+	parser := protoparse.Parser{
+		Accessor: protoparse.FileAccessor(func(filename string) (io.ReadCloser, error) {
+			if filename != name {
+				return nil, fmt.Errorf("import %s cannot be resolved from string: %w", filename, fs.ErrNotExist)
+			}
+			return io.NopCloser(strings.NewReader(content)), nil
+		}),
+	}
+
+	fds, err := parser.ParseFiles(name)
+	if err != nil {
+		return nil, err
+	}
+	return c.registerFileDescriptors(fds)
+}
+
+func (c *client) LoadProtoset(filename string) ([]methodInfo, error) {
+	if state := c.vu.State(); state != nil {
+		return nil, errors.New("load must be called in the init context")
+	}
+
+	initEnv := c.vu.InitEnv()
+	if initEnv == nil {
+		return nil, errors.New("missing init environment")
+	}
+
+	b, err := fsext.ReadFile(initEnv.FileSystems["file"], initEnv.GetAbsFilePath(filename))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read protoset file: %w", err)
+	}
 
 	fdset := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(b, fdset); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal protoset file: %w", err)
+	}
+	return c.registerMethods(fdset)
+}
 
-	seen := make(map[string]struct{})
-	for _, fd := range fds {
-		fdset.File = append(fdset.File, walkFileDescriptors(seen, fd)...)
+// LoadGlobal registers the methods of the service from the files linked into the binary,
+// which are registered in protoregistry.GlobalFiles by the generated Go code.
+func (c *client) LoadGlobal(serviceName string) ([]methodInfo, error) {
+	if state := c.vu.State(); state != nil {
+		return nil, errors.New("load must be called in the init context")
+	}
+
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(serviceName))
+	if err != nil {
+		return nil, fmt.Errorf("service %s is not registered globally: %w", serviceName, err)
+	}
+	if _, ok := d.(protoreflect.ServiceDescriptor); !ok {
+		return nil, fmt.Errorf("%s is not a service", serviceName)
+	}
+
+	fdset := &descriptorpb.FileDescriptorSet{}
+	fdset.File = walkGlobalFileDescriptors(make(map[string]struct{}), d.ParentFile())
+	return c.registerMethods(fdset)
+}
+
+// protosetContentTypes are the content types accepted from the servers of protoset files.
+var protosetContentTypes = []string{
+	"application/octet-stream",
+	"application/protobuf",
+	"application/x-protobuf",
+	"application/vnd.google.protobuf",
+}
+
+// LoadProtosetURL fetches the FileDescriptorSet from the URL with the headers and registers its methods.
+func (c *client) LoadProtosetURL(protosetURL string, headers sobek.Value) ([]methodInfo, error) {
+	if state := c.vu.State(); state != nil {
+		return nil, errors.New("load must be called in the init context")
+	}
+
+	req, err := http.NewRequestWithContext(c.vu.Context(), http.MethodGet, protosetURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid protoset URL: %w", err)
+	}
+	if !common.IsNullish(headers) {
+		values, ok := headers.Export().(map[string]any)
+		if !ok {
+			return nil, errors.New("headers must be an object with key-value pairs")
+		}
+		for k, v := range values {
+			if err := appendMetadata(req.Header, k, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	client := &http.Client{Timeout: protosetURLTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch protoset from %s: %w", protosetURL, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch protoset from %s: unexpected status %s", protosetURL, resp.Status)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || !slices.Contains(protosetContentTypes, mediaType) {
+			return nil, fmt.Errorf("failed to fetch protoset from %s: unexpected content type %s, expected one of %s",
+				protosetURL, contentType, strings.Join(protosetContentTypes, ", "))
+		}
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read protoset from %s: %w", protosetURL, err)
+	}
+
+	fdset := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(b, fdset); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal protoset from %s: %w", protosetURL, err)
 	}
 	return c.registerMethods(fdset)
 }
@@ -114,22 +326,117 @@ func (c *client) Connect(addr string, params sobek.Value) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	c.httpClient = &http.Client{
-		Transport: &http.Transport{
-			DialContext:       c.vu.State().Dialer.DialContext,
-			Proxy:             http.ProxyFromEnvironment,
-			MaxIdleConns:      1,
-			ForceAttemptHTTP2: false,
-		},
+	// the transports of calls and reflection choose TLS by the scheme
+	switch {
+	case p.tls != nil && p.tls.force:
+		c.addr.Scheme = "https"
+	case p.plaintext:
+		c.addr.Scheme = "http"
+	}
+	c.protocol = p.protocol
+	c.compression = p.compression
+	c.gzipLevel = p.compressionLevel
+	c.codecName = p.codec
+	c.contentSubtype = p.contentSubtype
+	c.deterministic = p.deterministic
+	c.userAgent = p.userAgent
+	c.timeout = p.timeout
+	c.tags = p.tags
+	c.maxTimeout = p.maxTimeout
+	c.dialTimeout = p.dialTimeout
+
+	dial := c.vu.State().Dialer.DialContext
+	if p.dialTimeout > 0 {
+		dial = dialWithTimeout(dial, p.dialTimeout)
+	}
+
+	var tlsConfig *tls.Config
+	if p.tls != nil {
+		tlsConfig, err = buildTLSConfig(p.tls)
+		if err != nil {
+			return false, err
+		}
+		if tlsConfig.InsecureSkipVerify {
+			c.vu.State().Logger.Warn("TLS certificate verification is disabled")
+		}
+	}
+
+	httpTransport := &http.Transport{
+		DialContext:         dial,
+		Proxy:               http.ProxyFromEnvironment,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: p.dialTimeout,
+		MaxIdleConns:        p.maxIdleConns,
+		MaxIdleConnsPerHost: p.maxIdleConnsPerHost,
+		ForceAttemptHTTP2:   p.http2,
+	}
+	if p.http1 {
+		// a non-nil empty map disables HTTP2 negotiation over TLS
+		httpTransport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	if p.http2 && p.keepAlive != nil {
+		h2Transport, err := http2.ConfigureTransports(httpTransport)
+		if err != nil {
+			return false, err
+		}
+		p.keepAlive.configure(h2Transport)
+	}
+
+	var transport http.RoundTripper = httpTransport
+	if p.protocol == protocolGRPC {
+		// gRPC requires HTTP2
+		h2Transport := newHTTP2Transport(c.addr, tlsConfig, dial)
+		p.keepAlive.configure(h2Transport)
+		transport = h2Transport
+	}
+	httpClient := &http.Client{}
+	if c.sharedHTTPClient != nil {
+		// keep the settings of the shared client, such as its timeout and cookie jar
+		*httpClient = *c.sharedHTTPClient
+		transport = c.sharedHTTPClient.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+	}
+	if p.protocol == protocolGRPCWeb {
+		// the text format can also be selected per call
+		transport = &grpcWebTextTransport{base: transport, enabled: p.grpcWebText}
+	}
+
+	httpClient.Transport = &authorityTransport{base: transport}
+	c.httpClient = httpClient
+	c.tlsConfig = tlsConfig
+	c.keepAlive = p.keepAlive
+	// reflection follows the protocol of the calls unless it is served separately
+	c.reflectProtocol = p.protocol
+	if p.reflectProtocol != "" {
+		c.reflectProtocol = p.reflectProtocol
 	}
 
 	if !p.reflect {
 		return true, nil
 	}
 
-	fdset, err := c.reflectServer(ctx, c.addr, p.metadata)
-	if err != nil {
-		return false, err
+	// reflectMetadata replaces metadata for the server reflection requests
+	header := p.metadata
+	if p.reflectMetadata != nil {
+		header = p.reflectMetadata
+	}
+	setUserAgent(header, c.userAgent)
+
+	// reuse the descriptors reflected from the same address during the VU's lifetime
+	// the services are part of the key because the descriptors of other services are not reflected
+	cacheKey := c.addr.String()
+	if len(p.reflectServices) > 0 {
+		cacheKey += "#" + strings.Join(p.reflectServices, ",")
+	}
+	fdset, ok := c.reflectionCache[cacheKey]
+	if !ok || p.refreshReflection {
+		fdset, err = c.reflectServer(ctx, c.addr, header, tlsConfig, p.keepAlive, c.reflectProtocol, p.reflectVersion, p.reflectServices)
+		if err != nil {
+			return false, err
+		}
+		c.reflectionCache[cacheKey] = fdset
 	}
 	_, err = c.registerMethods(fdset)
 	if err != nil {
@@ -139,21 +446,19 @@ func (c *client) Connect(addr string, params sobek.Value) (bool, error) {
 	return true, nil
 }
 
-func (c *client) reflectServer(ctx context.Context, addr *url.URL, header http.Header) (*descriptorpb.FileDescriptorSet, error) {
+func (c *client) reflectServer(ctx context.Context, addr *url.URL, header http.Header, tlsConfig *tls.Config, keepAlive *keepAliveParams, protocol, version string, allowlist []string) (*descriptorpb.FileDescriptorSet, error) {
 	// use HTTP2 transport because gRPC server reflection service provides bidirectional streaming RPC
-	transport := &http2.Transport{}
-	if addr.Scheme != "https" {
-		var dialer net.Dialer
-		transport = &http2.Transport{
-			AllowHTTP: true,
-			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
-				return dialer.DialContext(ctx, network, addr)
-			},
-		}
+	dialer := net.Dialer{Timeout: c.dialTimeout}
+	h2Transport := newHTTP2Transport(addr, tlsConfig, dialer.DialContext)
+	keepAlive.configure(h2Transport)
+
+	var transport http.RoundTripper = h2Transport
+	if version != "" {
+		transport = &reflectionVersionTransport{base: transport, version: version}
 	}
 
 	client := grpcreflect.NewClient(&http.Client{Transport: transport}, addr.String(),
-		connect.WithGRPCWeb(),
+		protocolOptions(protocol)...,
 	)
 
 	opts := []grpcreflect.ClientStreamOption{}
@@ -166,14 +471,44 @@ func (c *client) reflectServer(ctx context.Context, addr *url.URL, header http.H
 
 	names, err := stream.ListServices()
 	if err != nil {
-		return nil, err
+		switch {
+		case connect.CodeOf(err) == connect.CodeUnimplemented:
+			// the client has already fallen back to v1alpha when v1 is unimplemented
+			services := "neither grpc.reflection.v1 nor grpc.reflection.v1alpha is implemented"
+			if version != "" {
+				services = fmt.Sprintf("grpc.reflection.%s is not implemented", version)
+			}
+			return nil, fmt.Errorf("server reflection is not available on %s, %s; "+
+				"load the .proto files with load() instead: %w", addr, services, err)
+		case grpcreflect.IsReflectionStreamBroken(err):
+			return nil, fmt.Errorf("server reflection stream to %s was broken while listing services: %w", addr, err)
+		default:
+			return nil, fmt.Errorf("failed to list services using server reflection on %s: %w", addr, err)
+		}
+	}
+
+	// only the allowed services are resolved, which saves requests against servers with many services
+	if len(allowlist) > 0 {
+		advertised := make(map[protoreflect.FullName]bool, len(names))
+		for _, name := range names {
+			advertised[name] = true
+		}
+		names = make([]protoreflect.FullName, 0, len(allowlist))
+		for _, service := range allowlist {
+			name := protoreflect.FullName(service)
+			if !advertised[name] {
+				return nil, fmt.Errorf("service %s is not advertised by server reflection on %s", service, addr)
+			}
+			names = append(names, name)
+		}
 	}
 
 	fdset := &descriptorpb.FileDescriptorSet{}
 	for _, name := range names {
 		fds, err := stream.FileContainingSymbol(name)
 		if err != nil {
-			return nil, err
+			// the server advertised the service, so reflection is available but incomplete
+			return nil, fmt.Errorf("failed to resolve descriptors of service %s advertised by server reflection on %s: %w", name, addr, err)
 		}
 		fdset.File = append(fdset.File, fds...)
 	}
@@ -181,138 +516,316 @@ func (c *client) reflectServer(ctx context.Context, addr *url.URL, header http.H
 }
 
 type invokeResponse struct {
-	Header  http.Header
-	Trailer http.Header
+	Header  map[string][]string
+	Trailer map[string][]string
 	Message any
 
+	// Headers and Trailers hold the first value of each key in lower case, as gRPC metadata keys are.
+	Headers  map[string]string
+	Trailers map[string]string
+
 	Error        string
-	ErrorDetails []*connect.ErrorDetail
+	ErrorKind    string
+	ErrorDetails []errorDetail
 	Status       codes.Code
+
+	// HTTPVersion is the protocol of the HTTP response, such as HTTP/1.1.
+	HTTPVersion string
+	// Duration is the time taken by the call in milliseconds, as pushed to grpc_req_duration.
+	Duration float64
+	// HedgeAttempt is the attempt whose response is returned, starting from 1.
+	HedgeAttempt int
 }
 
 func (c *client) Invoke(method string, req sobek.Value, params sobek.Value) (*invokeResponse, error) {
-	md, ok := c.mds[method]
-	if !ok {
-		return nil, fmt.Errorf("method %s not found in file descriptors", method)
+	method, md, err := c.lookupMethod(method)
+	if err != nil {
+		return nil, err
 	}
 	if req == nil {
 		return nil, fmt.Errorf("request cannot be nil")
 	}
 
-	connectReq, ctm, timeout, err := c.buildRequest(md, req, params)
+	client, err := c.newConnectClient(method)
 	if err != nil {
 		return nil, err
 	}
-	c.setSystemTags(ctm, c.addr, method)
 
-	if timeout <= 0 {
-		// default timeout is 2 minutes
-		timeout = 2 * time.Minute
+	connectReq, p, err := c.buildRequest(md, req, params)
+	if err != nil {
+		return nil, err
 	}
+	c.setSystemTags(&p.tagsAndMeta, c.addr, method, md)
 
+	timeout := c.callTimeout(p)
+
+	// connect propagates the deadline to the server in the grpc-timeout header
 	ctx, cancel := context.WithTimeout(c.vu.Context(), timeout)
 	defer cancel()
-
-	resp, err := c.callUnary(ctx, method, connectReq, ctm)
-	if err != nil {
-		var connectErr *connect.Error
-		if errors.As(err, &connectErr) {
-			return &invokeResponse{
-				Error:        connectErr.Message(),
-				ErrorDetails: connectErr.Details(),
-				Status:       codes.Code(uint32(connectErr.Code())),
-			}, nil
-		}
-		return nil, err
+	if p.authority != "" {
+		ctx = withAuthority(ctx, p.authority)
+	}
+	if p.grpcWebText != nil {
+		ctx = withGRPCWebText(ctx, *p.grpcWebText)
 	}
 
-	message, err := convertMessageToJSON(md, resp.Msg.data)
+	resp, err := c.callUnaryWithRetry(ctx, client, connectReq, p)
+	c.pushReqFailed(p, err)
+	return c.newInvokeResponse(md, resp, err, p)
+}
+
+// pushReqFailed records the result of a unary call once, however many retries and hedged attempts it took.
+func (c *client) pushReqFailed(p *callParams, err error) {
+	code := codes.OK
 	if err != nil {
-		return nil, err
+		code = codes.Code(uint32(connect.CodeOf(err)))
 	}
-
-	return &invokeResponse{
-		Header:  resp.Header(),
-		Trailer: resp.Trailer(),
-		Message: message,
-	}, nil
+	pushReqFailed(c.vu.Context(), c.vu.State().Samples, c.metrics.reqFailed, &p.tagsAndMeta, code)
 }
 
-func (c *client) AsyncInvoke(method string, req sobek.Value, params sobek.Value) *sobek.Promise {
-	promise, resolve, reject := c.vu.Runtime().NewPromise()
+// AsyncInvoke returns a promise of the response with a cancel method which aborts the call.
+// A cancelled call resolves with the Canceled status.
+func (c *client) AsyncInvoke(method string, req sobek.Value, params sobek.Value) *sobek.Object {
+	rt := c.vu.Runtime()
+	promise, resolve, reject := rt.NewPromise()
+	promiseObject := rt.ToValue(promise).ToObject(rt)
 
-	md, ok := c.mds[method]
-	if !ok {
-		reject(fmt.Errorf("method %s not found in file descriptors", method))
-		return promise
+	ctx, cancel := context.WithCancel(c.vu.Context())
+	if err := promiseObject.Set("cancel", func() { cancel() }); err != nil {
+		cancel()
+		reject(err)
+		return promiseObject
+	}
+
+	method, md, err := c.lookupMethod(method)
+	if err != nil {
+		cancel()
+		reject(err)
+		return promiseObject
 	}
 	if req == nil {
+		cancel()
 		reject(fmt.Errorf("request cannot be nil"))
-		return promise
+		return promiseObject
 	}
 
-	connectReq, ctm, timeout, err := c.buildRequest(md, req, params)
+	client, err := c.newConnectClient(method)
 	if err != nil {
+		cancel()
 		reject(err)
-		return promise
+		return promiseObject
+	}
+
+	connectReq, p, err := c.buildRequest(md, req, params)
+	if err != nil {
+		cancel()
+		reject(err)
+		return promiseObject
 	}
-	c.setSystemTags(ctm, c.addr, method)
+	c.setSystemTags(&p.tagsAndMeta, c.addr, method, md)
 
 	callback := c.vu.RegisterCallback()
 
-	if timeout <= 0 {
-		// default timeout is 2 minutes
-		timeout = 2 * time.Minute
-	}
+	timeout := c.callTimeout(p)
 
 	go func() {
-		ctx, cancel := context.WithTimeout(c.vu.Context(), timeout)
 		defer cancel()
+		ctx, cancelTimeout := context.WithTimeout(ctx, timeout)
+		defer cancelTimeout()
+		if p.authority != "" {
+			ctx = withAuthority(ctx, p.authority)
+		}
+		if p.grpcWebText != nil {
+			ctx = withGRPCWebText(ctx, *p.grpcWebText)
+		}
 
-		resp, err := c.callUnary(ctx, method, connectReq, ctm)
+		resp, err := c.callUnaryWithRetry(ctx, client, connectReq, p)
+		c.pushReqFailed(p, err)
 
 		callback(func() error {
-			if err != nil {
-				var connectErr *connect.Error
-				if errors.As(err, &connectErr) {
-					resolve(&invokeResponse{
-						Error:        connectErr.Message(),
-						ErrorDetails: connectErr.Details(),
-						Status:       codes.Code(uint32(connectErr.Code())),
-					})
-					return nil
-				}
-				reject(err)
-				return nil // do not return error
-			}
-
-			message, err := convertMessageToJSON(md, resp.Msg.data)
+			r, err := c.newInvokeResponse(md, resp, err, p)
 			if err != nil {
 				reject(err)
 				return nil // do not return error
 			}
-
-			resolve(&invokeResponse{
-				Header:  resp.Header(),
-				Trailer: resp.Trailer(),
-				Message: message,
-			})
+			resolve(r)
 			return nil
 		})
 	}()
 
-	return promise
+	return promiseObject
 }
 
-func (c *client) callUnary(ctx context.Context, method string, req *connect.Request[dynamicpb.Message], ctm *metrics.TagsAndMeta) (*connect.Response[deferredMessage], error) {
-	client := connect.NewClient[dynamicpb.Message, deferredMessage](c.httpClient, c.addr.JoinPath(method).String(),
-		connect.WithCodec(protoCodec{}),
-		connect.WithGRPCWeb(),
-	)
+// InvokeMany calls the method with each request, at most concurrency calls at a time, and returns the responses in order.
+// The concurrency defaults to the number of requests.
+func (c *client) InvokeMany(method string, reqs []sobek.Value, params sobek.Value, concurrency sobek.Value) ([]*invokeResponse, error) {
+	method, md, err := c.lookupMethod(method)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := len(reqs)
+	if !common.IsNullish(concurrency) {
+		n, ok := concurrency.Export().(int64)
+		if !ok || n <= 0 {
+			return nil, errors.New("concurrency value must be a positive integer")
+		}
+		limit = int(n)
+	}
+
+	client, err := c.newConnectClient(method)
+	if err != nil {
+		return nil, err
+	}
+
+	connectReqs := make([]*connect.Request[dynamicpb.Message], len(reqs))
+	ps := make([]*callParams, len(reqs))
+	for i, req := range reqs {
+		if common.IsNullish(req) {
+			return nil, fmt.Errorf("request cannot be nil")
+		}
+		connectReqs[i], ps[i], err = c.buildRequest(md, req, params)
+		if err != nil {
+			return nil, err
+		}
+		c.setSystemTags(&ps[i].tagsAndMeta, c.addr, method, md)
+	}
+
+	resps := make([]*connect.Response[deferredMessage], len(reqs))
+	errs := make([]error, len(reqs))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, limit)
+	for i := range reqs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			p := ps[i]
+			timeout := c.callTimeout(p)
+
+			ctx, cancel := context.WithTimeout(c.vu.Context(), timeout)
+			defer cancel()
+			if p.authority != "" {
+				ctx = withAuthority(ctx, p.authority)
+			}
+			if p.grpcWebText != nil {
+				ctx = withGRPCWebText(ctx, *p.grpcWebText)
+			}
+
+			resps[i], errs[i] = c.callUnaryWithRetry(ctx, client, connectReqs[i], p)
+			c.pushReqFailed(p, errs[i])
+		}()
+	}
+	wg.Wait()
+
+	results := make([]*invokeResponse, len(reqs))
+	for i := range reqs {
+		r, err := c.newInvokeResponse(md, resps[i], errs[i], ps[i])
+		if err != nil {
+			// report the failure of the individual request instead of failing the whole batch
+			r = &invokeResponse{
+				Error:  err.Error(),
+				Status: codes.Code(uint32(connect.CodeOf(err))),
+			}
+		}
+		results[i] = r
+	}
+	return results, nil
+}
+
+// newInvokeResponse converts the result of a unary call into the response returned to JS.
+// Errors with a gRPC status are returned as a response with the status.
+func (c *client) newInvokeResponse(md protoreflect.MethodDescriptor, resp *connect.Response[deferredMessage], err error, p *callParams) (*invokeResponse, error) {
+	if err != nil {
+		var connectErr *connect.Error
+		if errors.As(err, &connectErr) {
+			// the headers and trailers of failed calls are merged, as servers often send them together
+			meta := binaryMetadata(connectErr.Meta())
+			return &invokeResponse{
+				Header:       meta,
+				Trailer:      meta,
+				Headers:      firstValues(meta),
+				Trailers:     firstValues(meta),
+				Error:        connectErr.Message(),
+				ErrorKind:    errorKind(connectErr),
+				ErrorDetails: c.decodeErrorDetails(connectErr.Details()),
+				Status:       codes.Code(uint32(connectErr.Code())),
+				HTTPVersion:  p.httpVersion,
+				Duration:     metrics.D(p.duration),
+				HedgeAttempt: p.hedgeAttempt,
+			}, nil
+		}
+		return nil, err
+	}
+
+	message, err := c.convertResponseMessage(md, resp.Msg.data, p)
+	if err != nil {
+		return nil, err
+	}
+
+	header, trailer := binaryMetadata(resp.Header()), binaryMetadata(resp.Trailer())
+	return &invokeResponse{
+		Header:       header,
+		Trailer:      trailer,
+		Headers:      firstValues(header),
+		Trailers:     firstValues(trailer),
+		Message:      message,
+		Status:       codes.OK,
+		HTTPVersion:  p.httpVersion,
+		Duration:     metrics.D(p.duration),
+		HedgeAttempt: p.hedgeAttempt,
+	}, nil
+}
+
+const (
+	errorKindStatus    = "status"
+	errorKindTransport = "transport"
+	errorKindDeadline  = "deadline"
+	errorKindCanceled  = "canceled"
+)
+
+// errorKind categorizes the error: the deadline and cancellation of the call,
+// a status sent by the server, or a failure to exchange messages with the server.
+func errorKind(err *connect.Error) string {
+	switch {
+	case err.Code() == connect.CodeDeadlineExceeded:
+		return errorKindDeadline
+	case err.Code() == connect.CodeCanceled:
+		return errorKindCanceled
+	case connect.IsWireError(err):
+		return errorKindStatus
+	default:
+		return errorKindTransport
+	}
+}
+
+func (c *client) callUnary(ctx context.Context, client *connect.Client[dynamicpb.Message, deferredMessage], req *connect.Request[dynamicpb.Message], p *callParams) (*connect.Response[deferredMessage], error) {
+	var t *tracer
+	if p.httpTrace {
+		t = &tracer{}
+		ctx = httptrace.WithClientTrace(ctx, t.clientTrace())
+	}
+
+	ctx = withHTTPVersion(ctx, &p.httpVersion)
+	timeout := remainingTimeout(ctx)
 
 	beginTime := time.Now()
 	resp, err := client.CallUnary(ctx, req)
 	endTime := time.Now()
+	p.duration = endTime.Sub(beginTime)
+
+	code := codes.OK
+	if err != nil {
+		code = codes.Code(uint32(connect.CodeOf(err)))
+	}
+	// the status is only known after the call, so every sample of the call is tagged with it here
+	ctm := metrics.TagsAndMeta{
+		Tags:     p.tagsAndMeta.Tags.With("grpc_status_code", strconv.Itoa(int(code))),
+		Metadata: p.tagsAndMeta.Metadata,
+	}
 
 	// push metrics
 	state := c.vu.State()
@@ -325,66 +838,283 @@ func (c *client) callUnary(ctx context.Context, method string, req *connect.Requ
 		Metadata: ctm.Metadata,
 		Value:    metrics.D(endTime.Sub(beginTime)),
 	})
+	if t != nil {
+		t.push(ctx, state.Samples, c.metrics, &ctm, endTime)
+	}
+	reqBytes, respBytes := proto.Size(req.Msg), 0
+	pushMessageSize(ctx, state.Samples, c.metrics.reqBytes, &ctm, reqBytes)
+	if err == nil {
+		respBytes = len(resp.Msg.data)
+		pushMessageSize(ctx, state.Samples, c.metrics.respBytes, &ctm, respBytes)
+	}
+
+	state.Logger.WithFields(logrus.Fields{
+		"url":        c.addr.JoinPath(req.Spec().Procedure).String(),
+		"duration":   endTime.Sub(beginTime).String(),
+		"timeout":    timeout.String(),
+		"status":     code.String(),
+		"req_bytes":  reqBytes,
+		"resp_bytes": respBytes,
+	}).Debug("gRPC call finished")
 
 	return resp, err
 }
 
-func (c *client) Stream(method string, req, params sobek.Value) (*sobek.Object, error) {
-	md, ok := c.mds[method]
-	if !ok {
-		return nil, fmt.Errorf("method %s not found in file descriptors", method)
+// callTimeout returns the timeout of a unary call, which falls back to the timeout of connect and then to the default timeout of the module.
+// The deadline takes precedence, and a past deadline results in a non-positive timeout which fails the call immediately.
+func (c *client) callTimeout(p *callParams) time.Duration {
+	if !p.deadline.IsZero() {
+		return time.Until(p.deadline)
+	}
+	if p.timeout > 0 {
+		return p.timeout
 	}
+	timeout := c.timeout
+	if timeout <= 0 && c.defaultTimeout != nil {
+		timeout = *c.defaultTimeout
+	}
+	if timeout <= 0 {
+		timeout = defaultCallTimeout
+	}
+	if c.maxTimeout > 0 && timeout > c.maxTimeout {
+		return c.maxTimeout
+	}
+	return timeout
+}
 
-	if req == nil {
-		return nil, fmt.Errorf("request cannot be nil")
+// streamTimeout returns the timeout of a stream, which falls back to the timeout of connect.
+// A zero timeout of the call opts the stream out of it, while maxTimeout still caps the stream.
+func (c *client) streamTimeout(p *callParams) time.Duration {
+	if p.timeout > 0 {
+		return p.timeout
+	}
+	timeout := c.timeout
+	if p.timeoutDisabled {
+		timeout = 0
 	}
+	if c.maxTimeout > 0 && (timeout <= 0 || timeout > c.maxTimeout) {
+		return c.maxTimeout
+	}
+	return timeout
+}
 
-	client := connect.NewClient[dynamicpb.Message, deferredMessage](c.httpClient, c.addr.JoinPath(method).String(),
-		connect.WithCodec(protoCodec{}),
-		connect.WithGRPCWeb(),
-	)
+// remainingTimeout returns the time left until the deadline of the context, or zero if it has no deadline.
+func remainingTimeout(ctx context.Context) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0
+	}
+	return time.Until(deadline)
+}
 
-	connectReq, ctm, timeout, err := c.buildRequest(md, req, params)
+func (c *client) Stream(method string, req, params sobek.Value) (*sobek.Object, error) {
+	s, err := c.startStream(method, req, params)
 	if err != nil {
 		return nil, err
 	}
-	c.setSystemTags(ctm, c.addr, method)
 
-	ctx := c.vu.Context()
+	if s.headersTimeout > 0 {
+		// block the event loop until the headers are received, so that scripts can read them synchronously
+		timer := time.NewTimer(s.headersTimeout)
+		defer timer.Stop()
+		select {
+		case <-s.opened:
+			s.Header = binaryMetadata(s.responseHeader)
+			s.Headers = firstValues(s.Header)
+		case <-timer.C:
+		}
+	}
+
+	rt := c.vu.Runtime()
+	return rt.ToValue(s).ToObject(rt), nil
+}
+
+// CollectStream returns a promise of the array of all messages of the stream, which resolves once the stream ends.
+// The promise rejects with the error of the stream.
+func (c *client) CollectStream(method string, req, params sobek.Value) *sobek.Promise {
+	promise, resolve, reject := c.vu.Runtime().NewPromise()
+
+	s, err := c.startStream(method, req, params)
+	if err != nil {
+		reject(err)
+		return promise
+	}
+
+	// the stream is not exposed, so this is the only listener
+	messages := []any{}
+	_ = s.eventListeners.add(eventTypeData, func(v sobek.Value) (sobek.Value, error) {
+		messages = append(messages, v.Export())
+		return sobek.Undefined(), nil
+	})
+	s.completion.wait(func(any) { resolve(messages) }, func(v any) { reject(v) })
+	return promise
+}
+
+func (c *client) startStream(method string, req, params sobek.Value) (*stream, error) {
+	method, md, err := c.lookupMethod(method)
+	if err != nil {
+		return nil, err
+	}
+
+	if req == nil {
+		return nil, fmt.Errorf("request cannot be nil")
+	}
+
+	client, err := c.newConnectClient(method)
+	if err != nil {
+		return nil, err
+	}
+
+	connectReq, p, err := c.buildRequest(md, req, params)
+	if err != nil {
+		return nil, err
+	}
+	c.setSystemTags(&p.tagsAndMeta, c.addr, method, md)
+
+	ctx := c.vu.Context()
 	var cancel context.CancelFunc
-	if timeout > 0 {
+	if !p.deadline.IsZero() {
+		ctx, cancel = context.WithDeadline(ctx, p.deadline)
+	} else if timeout := c.streamTimeout(p); timeout > 0 {
 		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		// streams are canceled by maxMessages and close
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	if p.authority != "" {
+		ctx = withAuthority(ctx, p.authority)
+	}
+	if p.grpcWebText != nil {
+		ctx = withGRPCWebText(ctx, *p.grpcWebText)
 	}
 
 	s := &stream{
 		vu:             c.vu,
 		metrics:        c.metrics,
-		tagsAndMeta:    ctm,
+		tagsAndMeta:    &p.tagsAndMeta,
 		client:         client,
 		md:             md,
+		url:            c.addr.JoinPath(method).String(),
+		codec:          p.codec,
+		marshalOptions: p.marshalOptions,
+		fieldMask:      p.fieldMask,
+		decodeResponse: p.decodeResponse,
+		errorDetails:   c.decodeErrorDetails,
 		eventListeners: newEventListeners(),
 		tq:             taskqueue.New(c.vu.RegisterCallback),
 		cancel:         cancel,
+
+		continueOnHandlerError: p.continueOnHandlerError,
+		maxMessages:            p.maxMessages,
+		consumeDelay:           p.consumeDelay,
+		consumeJitter:          p.consumeJitter,
+		headersTimeout:         p.headersTimeout,
+		includeHeadersInData:   p.includeHeadersInData,
+		opened:                 make(chan struct{}),
 	}
+	s.release = func() { c.untrackStream(s) }
 
+	c.trackStream(s)
 	if err := s.begin(ctx, connectReq); err != nil {
+		c.untrackStream(s)
+		cancel()
 		return nil, err
 	}
+	return s, nil
+}
 
-	rt := c.vu.Runtime()
-	return rt.ToValue(s).ToObject(rt), nil
+func (c *client) newConnectClient(method string) (*connect.Client[dynamicpb.Message, deferredMessage], error) {
+	if c.httpClient == nil {
+		return nil, errors.New("no gRPC Web connection, connect must be called first")
+	}
+
+	return connect.NewClient[dynamicpb.Message, deferredMessage](c.httpClient, c.addr.JoinPath(method).String(),
+		c.clientOptions()...,
+	), nil
+}
+
+func (c *client) clientOptions() []connect.ClientOption {
+	opts := []connect.ClientOption{
+		connect.WithCodec(c.codec()),
+	}
+	opts = append(opts, protocolOptions(c.protocol)...)
+	if c.compression != "" {
+		opts = append(opts, compressionOptions(c.gzipLevel)...)
+	}
+	return opts
+}
+
+// codec returns the codec encoding the messages of calls.
+func (c *client) codec() connect.Codec {
+	if c.codecName == codecNameJSON {
+		return jsonCodec{}
+	}
+	return protoCodec{name: c.contentSubtype, deterministic: c.deterministic}
+}
+
+func protocolOptions(protocol string) []connect.ClientOption {
+	switch protocol {
+	case protocolGRPC:
+		return []connect.ClientOption{connect.WithGRPC()}
+	case protocolConnect:
+		// the Connect protocol is used by default
+		return nil
+	default:
+		return []connect.ClientOption{connect.WithGRPCWeb()}
+	}
 }
 
 func (c *client) Close() error {
-	// noop
+	// the streams end with their end events, and without error events
+	c.streamsMu.Lock()
+	for s := range c.streams {
+		s.close()
+	}
+	c.streamsMu.Unlock()
+
+	// the connections of the shared client are owned by the program embedding the module
+	if c.httpClient != nil && c.sharedHTTPClient == nil {
+		c.httpClient.CloseIdleConnections()
+	}
+	c.httpClient = nil
+	c.addr = nil
 	return nil
 }
 
+func (c *client) registerFileDescriptors(fds []*desc.FileDescriptor) ([]methodInfo, error) {
+	fdset := &descriptorpb.FileDescriptorSet{}
+
+	seen := make(map[string]struct{})
+	for _, fd := range fds {
+		fdset.File = append(fdset.File, walkFileDescriptors(seen, fd)...)
+	}
+	return c.registerMethods(fdset)
+}
+
+// registerMethods merges the files into the ones registered so far and registers their methods.
+// Files already registered with the same name are replaced so that a schema reflected again takes effect,
+// and only the newly registered methods are returned.
 func (c *client) registerMethods(fdset *descriptorpb.FileDescriptorSet) ([]methodInfo, error) {
-	files, err := protodesc.NewFiles(fdset)
+	merged := &descriptorpb.FileDescriptorSet{}
+	seen := make(map[string]int)
+	for _, group := range [][]*descriptorpb.FileDescriptorProto{c.files.GetFile(), fdset.GetFile()} {
+		for _, fd := range group {
+			if i, ok := seen[fd.GetName()]; ok {
+				merged.File[i] = fd
+				continue
+			}
+			seen[fd.GetName()] = len(merged.File)
+			merged.File = append(merged.File, fd)
+		}
+	}
+	addWellKnownTypes(merged)
+
+	files, err := protodesc.NewFiles(merged)
 	if err != nil {
 		return nil, err
 	}
+	c.files = merged
+	c.registry.Store(files)
 
 	var info []methodInfo
 	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
@@ -396,33 +1126,287 @@ func (c *client) registerMethods(fdset *descriptorpb.FileDescriptorSet) ([]metho
 				md := mds.Get(j)
 
 				name := fmt.Sprintf("/%s/%s", sd.FullName(), md.Name())
+				if _, ok := c.mds[name]; !ok {
+					info = append(info, newMethodInfo(name, md))
+				}
 				c.mds[name] = md
-				info = append(info, methodInfo{
-					MethodInfo: grpc.MethodInfo{
-						Name:           string(md.Name()),
-						IsClientStream: md.IsStreamingClient(),
-						IsServerStream: md.IsStreamingServer(),
-					},
-					Package:    string(fd.Package()),
-					Service:    string(sd.Name()),
-					FullMethod: name,
-				})
 			}
 		}
 		return true
 	})
+	sort.Slice(info, func(i, j int) bool {
+		return info[i].FullMethod < info[j].FullMethod
+	})
 	return info, nil
 }
 
+func newMethodInfo(name string, md protoreflect.MethodDescriptor) methodInfo {
+	sd := md.Parent()
+	return methodInfo{
+		MethodInfo: grpc.MethodInfo{
+			Name:           string(md.Name()),
+			IsClientStream: md.IsStreamingClient(),
+			IsServerStream: md.IsStreamingServer(),
+		},
+		Package:          string(md.ParentFile().Package()),
+		Service:          string(sd.Name()),
+		FullMethod:       name,
+		IdempotencyLevel: idempotencyLevel(md).String(),
+		Idempotent:       isIdempotent(md),
+		InputType:        string(md.Input().FullName()),
+		OutputType:       string(md.Output().FullName()),
+	}
+}
+
+// Reset unregisters the loaded methods and their files, and drops the descriptors cached by reflection,
+// so that the next load or reflection starts clean.
+func (c *client) Reset() {
+	c.mds = make(map[string]protoreflect.MethodDescriptor)
+	c.files = &descriptorpb.FileDescriptorSet{}
+	c.registry.Store(nil)
+	c.reflectionCache = make(map[string]*descriptorpb.FileDescriptorSet)
+}
+
+func (c *client) ListMethods() []methodInfo {
+	info := make([]methodInfo, 0, len(c.mds))
+	for name, md := range c.mds {
+		info = append(info, newMethodInfo(name, md))
+	}
+	sort.Slice(info, func(i, j int) bool {
+		return info[i].FullMethod < info[j].FullMethod
+	})
+	return info
+}
+
+func (c *client) ListServices() []string {
+	seen := make(map[string]struct{})
+	services := []string{}
+	for _, md := range c.mds {
+		name := string(md.Parent().FullName())
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		services = append(services, name)
+	}
+	sort.Strings(services)
+	return services
+}
+
+// DescriptorSet returns the serialized FileDescriptorSet of the files which define the registered methods and their imports.
+// Imports precede the files depending on them.
+func (c *client) DescriptorSet() (sobek.ArrayBuffer, error) {
+	if len(c.mds) == 0 {
+		return sobek.ArrayBuffer{}, errors.New("no descriptors loaded, load or connect with reflect must be called first")
+	}
+
+	names := make([]string, 0, len(c.mds))
+	for name := range c.mds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fdset := &descriptorpb.FileDescriptorSet{}
+	seen := make(map[string]struct{})
+	var add func(fd protoreflect.FileDescriptor)
+	add = func(fd protoreflect.FileDescriptor) {
+		if _, ok := seen[fd.Path()]; ok {
+			return
+		}
+		seen[fd.Path()] = struct{}{}
+
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			add(imports.Get(i).FileDescriptor)
+		}
+		fdset.File = append(fdset.File, protodesc.ToFileDescriptorProto(fd))
+	}
+	for _, name := range names {
+		add(c.mds[name].ParentFile())
+	}
+
+	data, err := proto.Marshal(fdset)
+	if err != nil {
+		return sobek.ArrayBuffer{}, err
+	}
+	return c.vu.Runtime().NewArrayBuffer(data), nil
+}
+
+const (
+	importBaseCWD    = "cwd"
+	importBaseScript = "script"
+)
+
+type clientParams struct {
+	importPaths []string
+	importBase  string
+	protocol    string
+	tls         *tlsParams
+	userAgent   string
+	timeout     time.Duration
+	maxTimeout  time.Duration
+}
+
+func defaultClientParams() clientParams {
+	return clientParams{
+		importBase: importBaseCWD,
+		protocol:   protocolGRPCWeb,
+		userAgent:  defaultUserAgent(),
+	}
+}
+
+func (c *client) parseClientParams(params sobek.Value) (clientParams, error) {
+	result := defaultClientParams()
+
+	if common.IsNullish(params) {
+		return result, nil
+	}
+
+	rt := c.vu.Runtime()
+	paramsObject := params.ToObject(rt)
+
+	for _, k := range paramsObject.Keys() {
+		v := paramsObject.Get(k)
+
+		switch k {
+		case "importPaths":
+			if common.IsNullish(v) {
+				break
+			}
+
+			importPaths, ok := v.Export().([]any)
+			if !ok {
+				return clientParams{}, errors.New("importPaths value must be an array of strings")
+			}
+			for _, importPath := range importPaths {
+				value, ok := importPath.(string)
+				if !ok {
+					return clientParams{}, errors.New("importPaths value must be an array of strings")
+				}
+				result.importPaths = append(result.importPaths, value)
+			}
+		case "importBase":
+			importBase, ok := v.Export().(string)
+			if !ok {
+				return clientParams{}, errors.New("importBase value must be string")
+			}
+			switch importBase {
+			case importBaseCWD, importBaseScript:
+				result.importBase = importBase
+			default:
+				return clientParams{}, fmt.Errorf("unsupported importBase: %s", importBase)
+			}
+		case "protocol":
+			protocol, ok := v.Export().(string)
+			if !ok {
+				return clientParams{}, errors.New("protocol value must be string")
+			}
+			switch protocol {
+			case protocolGRPCWeb, protocolGRPC, protocolConnect:
+				result.protocol = protocol
+			default:
+				return clientParams{}, fmt.Errorf("unsupported protocol: %s", protocol)
+			}
+		case "tls":
+			if common.IsNullish(v) {
+				break
+			}
+
+			var err error
+			result.tls, err = parseTLSParams(v.Export())
+			if err != nil {
+				return clientParams{}, err
+			}
+			if err := readTLSFiles(c.initEnv, result.tls); err != nil {
+				return clientParams{}, err
+			}
+		case "userAgent":
+			var ok bool
+			result.userAgent, ok = v.Export().(string)
+			if !ok {
+				return clientParams{}, errors.New("userAgent value must be string")
+			}
+		case "timeout":
+			timeout, err := types.GetDurationValue(v.Export())
+			if err != nil {
+				return clientParams{}, fmt.Errorf("invalid timeout value: %w", err)
+			}
+			result.timeout = timeout
+		case "maxTimeout":
+			maxTimeout, err := parseMaxTimeout(v)
+			if err != nil {
+				return clientParams{}, err
+			}
+			result.maxTimeout = maxTimeout
+		}
+	}
+
+	return result, nil
+}
+
+const (
+	protocolGRPCWeb = "grpcweb"
+	protocolGRPC    = "grpc"
+	protocolConnect = "connect"
+)
+
+const compressionGzip = "gzip"
+
+const (
+	reflectVersionV1      = "v1"
+	reflectVersionV1Alpha = "v1alpha"
+)
+
+// protosetURLTimeout is the timeout of fetching protoset files.
+const protosetURLTimeout = 30 * time.Second
+
+// defaultMaxIdleConns is the default size of the idle connection pool shared by all hosts.
+const defaultMaxIdleConns = 100
+
+// defaultCallTimeout is the timeout of unary calls without timeout parameters unless set by setDefaultTimeout.
+const defaultCallTimeout = 2 * time.Minute
+
 type connectParams struct {
-	metadata http.Header
-	reflect  bool
+	metadata            http.Header
+	reflect             bool
+	refreshReflection   bool
+	reflectVersion      string
+	reflectMetadata     http.Header
+	reflectProtocol     string
+	reflectServices     []string
+	tls                 *tlsParams
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	protocol            string
+	grpcWebText         bool
+	http1               bool
+	http2               bool
+	plaintext           bool
+	keepAlive           *keepAliveParams
+	compression         string
+	compressionLevel    int
+	codec               string
+	contentSubtype      string
+	deterministic       bool
+	userAgent           string
+	timeout             time.Duration
+	maxTimeout          time.Duration
+	dialTimeout         time.Duration
+	tags                map[string]string
 }
 
 func (c *client) parseConnectParams(params sobek.Value) (connectParams, error) {
+	// the params of the constructor are overridden
 	result := connectParams{
-		metadata: http.Header{},
-		reflect:  false,
+		metadata:      http.Header{},
+		reflect:       false,
+		maxIdleConns:  defaultMaxIdleConns,
+		protocol:      c.params.protocol,
+		tls:           c.params.tls,
+		userAgent:     c.params.userAgent,
+		timeout:       c.params.timeout,
+		maxTimeout:    c.params.maxTimeout,
+		deterministic: true,
 	}
 
 	if common.IsNullish(params) {
@@ -442,6 +1426,45 @@ func (c *client) parseConnectParams(params sobek.Value) (connectParams, error) {
 			if !ok {
 				return result, errors.New("reflect value must be boolean")
 			}
+		case "refreshReflection":
+			var ok bool
+			result.refreshReflection, ok = v.Export().(bool)
+			if !ok {
+				return result, errors.New("refreshReflection value must be boolean")
+			}
+		case "reflectVersion":
+			version, ok := v.Export().(string)
+			if !ok {
+				return connectParams{}, errors.New("reflectVersion value must be string")
+			}
+			switch version {
+			case reflectVersionV1, reflectVersionV1Alpha:
+				result.reflectVersion = version
+			default:
+				return connectParams{}, fmt.Errorf("unsupported reflectVersion: %s", version)
+			}
+		case "reflectProtocol":
+			var err error
+			result.reflectProtocol, err = parseReflectProtocol(v)
+			if err != nil {
+				return connectParams{}, err
+			}
+		case "reflectServices":
+			if common.IsNullish(v) {
+				break
+			}
+
+			services, ok := v.Export().([]any)
+			if !ok {
+				return connectParams{}, errors.New("reflectServices value must be an array of strings")
+			}
+			for _, service := range services {
+				value, ok := service.(string)
+				if !ok {
+					return connectParams{}, errors.New("reflectServices value must be an array of strings")
+				}
+				result.reflectServices = append(result.reflectServices, value)
+			}
 		case "metadata":
 			if common.IsNullish(v) {
 				break
@@ -453,15 +1476,193 @@ func (c *client) parseConnectParams(params sobek.Value) (connectParams, error) {
 			}
 			for hk, hv := range metadata {
 				// TODO: support Binary-valued keys
-				value, ok := hv.(string)
-				if !ok {
-					return connectParams{}, fmt.Errorf("%s value must be string", hk)
+				if err := appendMetadata(result.metadata, hk, hv); err != nil {
+					return connectParams{}, err
 				}
-				result.metadata[hk] = append(result.metadata[hk], value)
+			}
+		case "tags":
+			if common.IsNullish(v) {
+				break
+			}
+
+			tagsAndMeta := c.vu.State().Tags.GetCurrentValues()
+			if err := common.ApplyCustomUserTags(rt, &tagsAndMeta, v); err != nil {
+				return connectParams{}, fmt.Errorf("metric tags: %w", err)
+			}
+			result.tags = make(map[string]string)
+			for _, tk := range v.ToObject(rt).Keys() {
+				result.tags[tk], _ = tagsAndMeta.Tags.Get(tk)
+			}
+		case "reflectMetadata":
+			if common.IsNullish(v) {
+				break
+			}
+
+			metadata, ok := v.Export().(map[string]any)
+			if !ok {
+				return connectParams{}, fmt.Errorf("reflectMetadata must be an object with key-value pairs")
+			}
+			result.reflectMetadata = http.Header{}
+			for hk, hv := range metadata {
+				// TODO: support Binary-valued keys
+				if err := appendMetadata(result.reflectMetadata, hk, hv); err != nil {
+					return connectParams{}, err
+				}
+			}
+		case "tls":
+			if common.IsNullish(v) {
+				break
+			}
+
+			var err error
+			result.tls, err = parseTLSParams(v.Export())
+			if err != nil {
+				return connectParams{}, err
+			}
+			// files are read through the init file system even when connecting in the VU context
+			if err := readTLSFiles(c.initEnv, result.tls); err != nil {
+				return connectParams{}, err
+			}
+		case "maxIdleConns":
+			n, ok := v.Export().(int64)
+			if !ok || n < 0 {
+				return connectParams{}, errors.New("maxIdleConns value must be a non-negative integer")
+			}
+			result.maxIdleConns = int(n)
+		case "maxIdleConnsPerHost":
+			n, ok := v.Export().(int64)
+			if !ok || n < 0 {
+				return connectParams{}, errors.New("maxIdleConnsPerHost value must be a non-negative integer")
+			}
+			result.maxIdleConnsPerHost = int(n)
+		case "protocol":
+			protocol, ok := v.Export().(string)
+			if !ok {
+				return connectParams{}, errors.New("protocol value must be string")
+			}
+			switch protocol {
+			case protocolGRPCWeb, protocolGRPC, protocolConnect:
+				result.protocol = protocol
+			default:
+				return connectParams{}, fmt.Errorf("unsupported protocol: %s", protocol)
+			}
+		case "grpcWebText":
+			var ok bool
+			result.grpcWebText, ok = v.Export().(bool)
+			if !ok {
+				return connectParams{}, errors.New("grpcWebText value must be boolean")
+			}
+		case "http1":
+			var ok bool
+			result.http1, ok = v.Export().(bool)
+			if !ok {
+				return connectParams{}, errors.New("http1 value must be boolean")
+			}
+		case "http2":
+			var ok bool
+			result.http2, ok = v.Export().(bool)
+			if !ok {
+				return connectParams{}, errors.New("http2 value must be boolean")
+			}
+		case "plaintext":
+			var ok bool
+			result.plaintext, ok = v.Export().(bool)
+			if !ok {
+				return connectParams{}, errors.New("plaintext value must be boolean")
+			}
+		case "timeout":
+			timeout, err := types.GetDurationValue(v.Export())
+			if err != nil {
+				return connectParams{}, fmt.Errorf("invalid timeout value: %w", err)
+			}
+			result.timeout = timeout
+		case "maxTimeout":
+			maxTimeout, err := parseMaxTimeout(v)
+			if err != nil {
+				return connectParams{}, err
+			}
+			result.maxTimeout = maxTimeout
+		case "dialTimeout":
+			dialTimeout, err := types.GetDurationValue(v.Export())
+			if err != nil {
+				return connectParams{}, fmt.Errorf("invalid dialTimeout value: %w", err)
+			}
+			if dialTimeout <= 0 {
+				return connectParams{}, errors.New("dialTimeout value must be positive")
+			}
+			result.dialTimeout = dialTimeout
+		case "keepAlive":
+			if common.IsNullish(v) {
+				break
+			}
+
+			var err error
+			result.keepAlive, err = parseKeepAliveParams(v.Export())
+			if err != nil {
+				return connectParams{}, err
+			}
+		case "userAgent":
+			var ok bool
+			result.userAgent, ok = v.Export().(string)
+			if !ok {
+				return connectParams{}, errors.New("userAgent value must be string")
+			}
+		case "compression":
+			if common.IsNullish(v) {
+				break
+			}
+
+			var err error
+			result.compression, result.compressionLevel, err = parseCompression(v.Export())
+			if err != nil {
+				return connectParams{}, err
+			}
+		case "codec":
+			codec, ok := v.Export().(string)
+			if !ok {
+				return connectParams{}, errors.New("codec value must be string")
+			}
+			switch codec {
+			case codecNameProto, codecNameJSON:
+				result.codec = codec
+			default:
+				return connectParams{}, fmt.Errorf("unsupported codec: %s", codec)
+			}
+		case "contentSubtype":
+			var ok bool
+			result.contentSubtype, ok = v.Export().(string)
+			if !ok {
+				return connectParams{}, errors.New("contentSubtype value must be string")
+			}
+			if err := validateContentSubtype(result.contentSubtype); err != nil {
+				return connectParams{}, err
+			}
+		case "deterministicMarshal":
+			var ok bool
+			result.deterministic, ok = v.Export().(bool)
+			if !ok {
+				return connectParams{}, errors.New("deterministicMarshal value must be boolean")
 			}
 		}
 	}
 
+	if result.grpcWebText && result.protocol != protocolGRPCWeb {
+		return connectParams{}, errors.New("grpcWebText is only supported with grpcweb protocol")
+	}
+	if result.http1 && result.http2 {
+		return connectParams{}, errors.New("http1 and http2 cannot be enabled together")
+	}
+	if result.keepAlive != nil && result.protocol != protocolGRPC && !result.http2 {
+		// HTTP/1.1 has no ping frames, so the health check would be silently ignored
+		return connectParams{}, errors.New("keepAlive requires HTTP/2, such as the grpc protocol or http2: true")
+	}
+	if result.plaintext && result.tls != nil && result.tls.force {
+		return connectParams{}, errors.New("plaintext and tls force cannot be enabled together")
+	}
+	if result.codec == codecNameJSON && result.contentSubtype != "" {
+		return connectParams{}, errors.New("contentSubtype is only supported with proto codec")
+	}
+
 	return result, nil
 }
 
@@ -469,6 +1670,39 @@ type callParams struct {
 	metadata    http.Header
 	tagsAndMeta metrics.TagsAndMeta
 	timeout     time.Duration
+	// timeoutDisabled is set by a zero timeout, which runs streams without the timeout of connect.
+	timeoutDisabled  bool
+	deadline         time.Time
+	authority        string
+	httpTrace        bool
+	marshalOptions   protojson.MarshalOptions
+	unmarshalOptions protojson.UnmarshalOptions
+	raw              bool
+	decodeResponse   bool
+	fieldMask        []string
+	validate         bool
+	trace            bool
+	retry            *retryParams
+	hedge            *hedgeParams
+	grpcWebText      *bool
+
+	continueOnHandlerError bool
+	maxMessages            int
+	consumeDelay           time.Duration
+	consumeJitter          time.Duration
+	headersTimeout         time.Duration
+	includeHeadersInData   bool
+
+	// codec is the codec of the connection when the request is built.
+	codec connect.Codec
+	// httpVersion is recorded by the transport when the response is received.
+	httpVersion string
+	// duration is recorded when the call finishes.
+	duration time.Duration
+	// idempotent is whether the method may be retried.
+	idempotent bool
+	// hedgeAttempt is the attempt whose response is returned, starting from 1.
+	hedgeAttempt int
 }
 
 func (c *client) parseCallParams(params sobek.Value) (callParams, error) {
@@ -477,7 +1711,13 @@ func (c *client) parseCallParams(params sobek.Value) (callParams, error) {
 	result := callParams{
 		metadata:    http.Header{},
 		tagsAndMeta: c.vu.State().Tags.GetCurrentValues(),
-		timeout:     0,
+		marshalOptions: protojson.MarshalOptions{
+			EmitUnpopulated: true,
+		},
+		decodeResponse: true,
+	}
+	for k, v := range c.tags {
+		result.tagsAndMeta.SetTag(k, v)
 	}
 
 	if params != nil {
@@ -497,11 +1737,9 @@ func (c *client) parseCallParams(params sobek.Value) (callParams, error) {
 				}
 				for hk, hv := range metadata {
 					// TODO: support Binary-valued keys
-					value, ok := hv.(string)
-					if !ok {
-						return callParams{}, fmt.Errorf("%s value must be string", hk)
+					if err := appendMetadata(result.metadata, hk, hv); err != nil {
+						return callParams{}, err
 					}
-					result.metadata[hk] = append(result.metadata[hk], value)
 				}
 			case "tags":
 				if err := common.ApplyCustomUserTags(rt, &result.tagsAndMeta, paramsObject.Get(k)); err != nil {
@@ -513,41 +1751,316 @@ func (c *client) parseCallParams(params sobek.Value) (callParams, error) {
 					return result, fmt.Errorf("invalid timeout value: %w", err)
 				}
 				result.timeout = timeout
+				result.timeoutDisabled = timeout == 0
+			case "deadline":
+				deadline, err := parseDeadline(v)
+				if err != nil {
+					return result, err
+				}
+				result.deadline = deadline
+			case "authority":
+				if common.IsNullish(v) {
+					break
+				}
+
+				var ok bool
+				result.authority, ok = v.Export().(string)
+				if !ok {
+					return result, errors.New("authority value must be string")
+				}
+			case "httpTrace":
+				var ok bool
+				result.httpTrace, ok = v.Export().(bool)
+				if !ok {
+					return result, errors.New("httpTrace value must be boolean")
+				}
+			case "discardUnknownFields":
+				var ok bool
+				result.unmarshalOptions.DiscardUnknown, ok = v.Export().(bool)
+				if !ok {
+					return result, errors.New("discardUnknownFields value must be boolean")
+				}
+			case "raw":
+				var ok bool
+				result.raw, ok = v.Export().(bool)
+				if !ok {
+					return result, errors.New("raw value must be boolean")
+				}
+			case "emitUnpopulated":
+				var ok bool
+				result.marshalOptions.EmitUnpopulated, ok = v.Export().(bool)
+				if !ok {
+					return result, errors.New("emitUnpopulated value must be boolean")
+				}
+			case "decodeResponse":
+				var ok bool
+				result.decodeResponse, ok = v.Export().(bool)
+				if !ok {
+					return result, errors.New("decodeResponse value must be boolean")
+				}
+			case "fieldMask":
+				if common.IsNullish(v) {
+					break
+				}
+
+				paths, ok := v.Export().([]any)
+				if !ok {
+					return result, errors.New("fieldMask value must be an array of strings")
+				}
+				for _, path := range paths {
+					value, ok := path.(string)
+					if !ok {
+						return result, errors.New("fieldMask value must be an array of strings")
+					}
+					result.fieldMask = append(result.fieldMask, value)
+				}
+			case "trace":
+				var ok bool
+				result.trace, ok = v.Export().(bool)
+				if !ok {
+					return result, errors.New("trace value must be boolean")
+				}
+			case "validate":
+				var ok bool
+				result.validate, ok = v.Export().(bool)
+				if !ok {
+					return result, errors.New("validate value must be boolean")
+				}
+			case "retry":
+				if common.IsNullish(v) {
+					break
+				}
+
+				var err error
+				result.retry, err = parseRetryParams(v.Export())
+				if err != nil {
+					return result, err
+				}
+			case "hedge":
+				if common.IsNullish(v) {
+					break
+				}
+
+				var err error
+				result.hedge, err = parseHedgeParams(v.Export())
+				if err != nil {
+					return result, err
+				}
+			case "responseFormat":
+				if common.IsNullish(v) {
+					break
+				}
+
+				if err := parseResponseFormat(v.Export(), &result.marshalOptions); err != nil {
+					return result, err
+				}
+			case "continueOnHandlerError":
+				var ok bool
+				result.continueOnHandlerError, ok = v.Export().(bool)
+				if !ok {
+					return result, errors.New("continueOnHandlerError value must be boolean")
+				}
+			case "consumeDelay":
+				consumeDelay, err := types.GetDurationValue(v.Export())
+				if err != nil || consumeDelay < 0 {
+					return result, errors.New("consumeDelay value must be a non-negative duration")
+				}
+				result.consumeDelay = consumeDelay
+			case "consumeJitter":
+				consumeJitter, err := types.GetDurationValue(v.Export())
+				if err != nil || consumeJitter < 0 {
+					return result, errors.New("consumeJitter value must be a non-negative duration")
+				}
+				result.consumeJitter = consumeJitter
+			case "headersTimeout":
+				headersTimeout, err := types.GetDurationValue(v.Export())
+				if err != nil || headersTimeout <= 0 {
+					return result, errors.New("headersTimeout value must be a positive duration")
+				}
+				result.headersTimeout = headersTimeout
+			case "includeHeadersInData":
+				var ok bool
+				result.includeHeadersInData, ok = v.Export().(bool)
+				if !ok {
+					return result, errors.New("includeHeadersInData value must be boolean")
+				}
+			case "maxMessages":
+				n, ok := v.Export().(int64)
+				if !ok || n <= 0 {
+					return result, errors.New("maxMessages value must be a positive integer")
+				}
+				result.maxMessages = int(n)
+			case "grpcWebText":
+				grpcWebText, ok := v.Export().(bool)
+				if !ok {
+					return result, errors.New("grpcWebText value must be boolean")
+				}
+				if grpcWebText && c.protocol != protocolGRPCWeb {
+					return result, errors.New("grpcWebText is only supported with grpcweb protocol")
+				}
+				result.grpcWebText = &grpcWebText
 			}
 		}
 	}
+
+	// maxTimeout caps the timeout regardless of the script, and the timeout left unset is capped by callTimeout and streamTimeout
+	if c.maxTimeout > 0 && result.timeout > c.maxTimeout {
+		c.vu.State().Logger.Debugf("clamping timeout %s to maxTimeout %s", result.timeout, c.maxTimeout)
+		result.timeout = c.maxTimeout
+	}
+	if c.maxTimeout > 0 && !result.deadline.IsZero() {
+		if maxDeadline := time.Now().Add(c.maxTimeout); result.deadline.After(maxDeadline) {
+			c.vu.State().Logger.Debugf("clamping deadline %s to maxTimeout %s", result.deadline, c.maxTimeout)
+			result.deadline = maxDeadline
+		}
+	}
 	return result, nil
 }
 
-func (c *client) buildRequest(md protoreflect.MethodDescriptor, req sobek.Value, params sobek.Value) (*connect.Request[dynamicpb.Message], *metrics.TagsAndMeta, time.Duration, error) {
-	rt := c.vu.Runtime()
+// parseDeadline parses the absolute deadline given as milliseconds since the epoch, an ISO 8601 string or a Date.
+func parseDeadline(v sobek.Value) (time.Time, error) {
+	switch deadline := v.Export().(type) {
+	case int64:
+		return time.UnixMilli(deadline), nil
+	case float64:
+		return time.UnixMilli(int64(deadline)), nil
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, deadline)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid deadline value: %w", err)
+		}
+		return t, nil
+	case time.Time:
+		return deadline, nil
+	default:
+		return time.Time{}, errors.New("deadline value must be milliseconds since the epoch, an ISO 8601 string or a Date")
+	}
+}
 
-	b, err := req.ToObject(rt).MarshalJSON()
+func parseMaxTimeout(v sobek.Value) (time.Duration, error) {
+	maxTimeout, err := types.GetDurationValue(v.Export())
 	if err != nil {
-		return nil, nil, 0, err
+		return 0, fmt.Errorf("invalid maxTimeout value: %w", err)
 	}
-	reqdm := dynamicpb.NewMessage(md.Input())
-	err = protojson.Unmarshal(b, reqdm)
-	if err != nil {
-		return nil, nil, 0, err
+	if maxTimeout <= 0 {
+		return 0, errors.New("maxTimeout value must be positive")
 	}
+	return maxTimeout, nil
+}
 
-	r := connect.NewRequest(reqdm)
+func parseResponseFormat(v any, opts *protojson.MarshalOptions) error {
+	format, ok := v.(map[string]any)
+	if !ok {
+		return errors.New("responseFormat must be an object with key-value pairs")
+	}
+
+	for k, v := range format {
+		var target *bool
+		switch k {
+		case "useProtoNames":
+			target = &opts.UseProtoNames
+		case "useEnumNumbers":
+			target = &opts.UseEnumNumbers
+		case "emitUnpopulated":
+			target = &opts.EmitUnpopulated
+		default:
+			continue
+		}
 
+		value, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("responseFormat %s value must be boolean", k)
+		}
+		*target = value
+	}
+	return nil
+}
+
+func (c *client) buildRequest(md protoreflect.MethodDescriptor, req sobek.Value, params sobek.Value) (*connect.Request[dynamicpb.Message], *callParams, error) {
 	p, err := c.parseCallParams(params)
 	if err != nil {
-		return nil, nil, 0, err
+		return nil, nil, err
+	}
+
+	if err := validateFieldMask(md.Output(), p.fieldMask); err != nil {
+		return nil, nil, fmt.Errorf("invalid fieldMask: %w", err)
+	}
+
+	reqdm, err := c.newRequestMessage(md, req, &p)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// headers
+	r := connect.NewRequest(reqdm)
+	if err := c.prepareRequestHeader(md, r.Header(), reqdm, &p); err != nil {
+		return nil, nil, err
+	}
+	return r, &p, nil
+}
+
+// prepareRequestHeader sets the codec of the call and adds the metadata of the call, the metadata provider,
+// the user agent and the trace context to the header, which the request interceptor is given at last.
+func (c *client) prepareRequestHeader(md protoreflect.MethodDescriptor, header http.Header, msg *dynamicpb.Message, p *callParams) error {
+	p.codec = c.codec()
+	p.idempotent = isIdempotent(md)
+
+	method := fmt.Sprintf("/%s/%s", md.Parent().FullName(), md.Name())
+
+	provided, err := c.provideMetadata(method)
+	if err != nil {
+		return err
+	}
+	for k, v := range provided {
+		// the metadata of the call takes precedence
+		if !hasHeader(p.metadata, k) {
+			header[k] = v
+		}
+	}
 	for k, v := range p.metadata {
-		r.Header()[k] = v
+		header[k] = v
 	}
+	setUserAgent(header, c.userAgent)
+	// the trace context in metadata is kept, so scripts can continue their own traces
+	if p.trace && !hasHeader(header, "traceparent") {
+		traceparent, err := newTraceparent()
+		if err != nil {
+			return err
+		}
+		header.Set("traceparent", traceparent)
+	}
+	return c.interceptRequest(method, header, msg)
+}
+
+// grpcPackageTag is the tag of the package of the service, which is enabled together with the service system tag.
+const grpcPackageTag = "grpc_package"
 
-	return r, &p.tagsAndMeta, p.timeout, nil
+// newRequestMessage converts the request given as an object or serialized bytes into the input message of the method.
+func (c *client) newRequestMessage(md protoreflect.MethodDescriptor, req sobek.Value, p *callParams) (*dynamicpb.Message, error) {
+	reqdm := dynamicpb.NewMessage(md.Input())
+	if data, ok := requestBytes(req); ok {
+		// unknown fields of serialized requests are kept and sent
+		if err := proto.Unmarshal(data, reqdm); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal the serialized request: %w", err)
+		}
+		return reqdm, nil
+	}
+
+	b, err := req.ToObject(c.vu.Runtime()).MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	if p.validate {
+		if err := validateRequest(md.Input(), b, p.unmarshalOptions.DiscardUnknown); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.unmarshalOptions.Unmarshal(b, reqdm); err != nil {
+		return nil, err
+	}
+	return reqdm, nil
 }
 
-func (c *client) setSystemTags(ctm *metrics.TagsAndMeta, addr *url.URL, method string) {
+func (c *client) setSystemTags(ctm *metrics.TagsAndMeta, addr *url.URL, method string, md protoreflect.MethodDescriptor) {
 	state := c.vu.State()
 	if state.Options.SystemTags.Has(metrics.TagURL) {
 		ctm.SetSystemTagOrMeta(metrics.TagURL, addr.JoinPath(method).String())
@@ -558,6 +2071,29 @@ func (c *client) setSystemTags(ctm *metrics.TagsAndMeta, addr *url.URL, method s
 		ctm.SetSystemTagOrMetaIfEnabled(state.Options.SystemTags, metrics.TagService, parts[0])
 		ctm.SetSystemTagOrMetaIfEnabled(state.Options.SystemTags, metrics.TagMethod, parts[1])
 	}
+
+	// custom tags can't be registered as system tags
+	if pkg := md.ParentFile().Package(); pkg != "" && state.Options.SystemTags.Has(metrics.TagService) {
+		ctm.SetTag(grpcPackageTag, string(pkg))
+	}
+}
+
+func idempotencyLevel(md protoreflect.MethodDescriptor) descriptorpb.MethodOptions_IdempotencyLevel {
+	opts, ok := md.Options().(*descriptorpb.MethodOptions)
+	if !ok {
+		return descriptorpb.MethodOptions_IDEMPOTENCY_UNKNOWN
+	}
+	return opts.GetIdempotencyLevel()
+}
+
+// isIdempotent reports whether the method is declared safe to call again, so that failed calls may be retried.
+func isIdempotent(md protoreflect.MethodDescriptor) bool {
+	switch idempotencyLevel(md) {
+	case descriptorpb.MethodOptions_NO_SIDE_EFFECTS, descriptorpb.MethodOptions_IDEMPOTENT:
+		return true
+	default:
+		return false
+	}
 }
 
 func walkFileDescriptors(seen map[string]struct{}, fd *desc.FileDescriptor) []*descriptorpb.FileDescriptorProto {
@@ -577,13 +2113,41 @@ func walkFileDescriptors(seen map[string]struct{}, fd *desc.FileDescriptor) []*d
 	return fds
 }
 
-func convertMessageToJSON(md protoreflect.MethodDescriptor, data []byte) (any, error) {
+func walkGlobalFileDescriptors(seen map[string]struct{}, fd protoreflect.FileDescriptor) []*descriptorpb.FileDescriptorProto {
+	fds := []*descriptorpb.FileDescriptorProto{}
+
+	if _, ok := seen[fd.Path()]; ok {
+		return fds
+	}
+	seen[fd.Path()] = struct{}{}
+	fds = append(fds, protodesc.ToFileDescriptorProto(fd))
+
+	imports := fd.Imports()
+	for i := 0; i < imports.Len(); i++ {
+		deps := walkGlobalFileDescriptors(seen, imports.Get(i).FileDescriptor)
+		fds = append(fds, deps...)
+	}
+
+	return fds
+}
+
+// convertResponseMessage converts the serialized response message into the value returned to JS.
+func (c *client) convertResponseMessage(md protoreflect.MethodDescriptor, data []byte, p *callParams) (any, error) {
+	if p.raw {
+		return c.vu.Runtime().NewArrayBuffer(data), nil
+	}
+	if !p.decodeResponse {
+		return nil, nil
+	}
+	return convertMessageToJSON(md, data, p.codec, p.marshalOptions, p.fieldMask)
+}
+
+func convertMessageToJSON(md protoreflect.MethodDescriptor, data []byte, codec connect.Codec, marshaler protojson.MarshalOptions, fieldMask []string) (any, error) {
 	msg := dynamicpb.NewMessage(md.Output())
-	if err := proto.Unmarshal(data, msg); err != nil {
+	if err := codec.Unmarshal(data, msg); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal the message: %w", err)
 	}
 
-	marshaler := protojson.MarshalOptions{EmitUnpopulated: true}
 	raw, err := marshaler.Marshal(msg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal the message into JSON: %w", err)
@@ -593,5 +2157,8 @@ func convertMessageToJSON(md protoreflect.MethodDescriptor, data []byte) (any, e
 	if err := json.Unmarshal(raw, &resp); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal the JSON message: %w", err)
 	}
+	if obj, ok := resp.(map[string]any); ok && len(fieldMask) > 0 {
+		maskJSON(md.Output(), obj, fieldMask, marshaler.UseProtoNames)
+	}
 	return resp, nil
 }
@@ -0,0 +1,185 @@
+package grpcweb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/grafana/sobek"
+	"go.k6.io/k6/metrics"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// clientStream is a client streaming call, whose messages are sent by write and whose response is received by closeAndReceive.
+type clientStream struct {
+	client *client
+	md     protoreflect.MethodDescriptor
+	p      *callParams
+	stream *connect.ClientStreamForClient[dynamicpb.Message, deferredMessage]
+	cancel context.CancelFunc
+
+	beginTime time.Time
+	// closed is set by closeAndReceive, and only accessed on the event loop.
+	closed bool
+}
+
+// ClientStream starts a client streaming call. The request headers are sent with the first message.
+func (c *client) ClientStream(method string, params sobek.Value) (*clientStream, error) {
+	method, md, err := c.lookupMethod(method)
+	if err != nil {
+		return nil, err
+	}
+	if !md.IsStreamingClient() || md.IsStreamingServer() {
+		return nil, fmt.Errorf("%s is not a client streaming method", method)
+	}
+
+	client, err := c.newConnectClient(method)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := c.parseCallParams(params)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	if err := c.prepareRequestHeader(md, header, nil, &p); err != nil {
+		return nil, err
+	}
+	c.setSystemTags(&p.tagsAndMeta, c.addr, method, md)
+
+	ctx := c.vu.Context()
+	var cancel context.CancelFunc
+	if !p.deadline.IsZero() {
+		ctx, cancel = context.WithDeadline(ctx, p.deadline)
+	} else if timeout := c.streamTimeout(&p); timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	if p.authority != "" {
+		ctx = withAuthority(ctx, p.authority)
+	}
+	if p.grpcWebText != nil {
+		ctx = withGRPCWebText(ctx, *p.grpcWebText)
+	}
+
+	stream := client.CallClientStream(ctx)
+	for k, v := range header {
+		stream.RequestHeader()[k] = v
+	}
+
+	s := &clientStream{
+		client:    c,
+		md:        md,
+		p:         &p,
+		stream:    stream,
+		cancel:    cancel,
+		beginTime: time.Now(),
+	}
+	// the call is released once it completes or is canceled by close, the deadline or the end of the VU context,
+	// so that it does not outlive the iteration when closeAndReceive is not called
+	c.trackStream(s)
+	context.AfterFunc(ctx, func() {
+		c.untrackStream(s)
+	})
+	return s, nil
+}
+
+// close cancels the call, which rejects the promise of closeAndReceive.
+func (s *clientStream) close() {
+	s.cancel()
+}
+
+// Write sends the message, which is an object or serialized bytes like the request of invoke.
+func (s *clientStream) Write(req sobek.Value) error {
+	if s.closed {
+		return errors.New("cannot write to the client stream after closeAndReceive")
+	}
+
+	msg, err := s.client.newRequestMessage(s.md, req, s.p)
+	if err != nil {
+		return err
+	}
+	// the error of the call is returned by closeAndReceive
+	if err := s.stream.Send(msg); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	state := s.client.vu.State()
+	pushMessageSize(s.client.vu.Context(), state.Samples, s.client.metrics.reqBytes, &s.p.tagsAndMeta, proto.Size(msg))
+	return nil
+}
+
+// CloseAndReceive half-closes the stream and returns a promise of the response message.
+// The promise rejects with the error of the call, which has the same shape as the error event of stream.
+func (s *clientStream) CloseAndReceive() (*sobek.Promise, error) {
+	if s.closed {
+		return nil, errors.New("closeAndReceive was already called")
+	}
+	s.closed = true
+
+	promise, resolve, reject := s.client.vu.Runtime().NewPromise()
+	callback := s.client.vu.RegisterCallback()
+
+	go func() {
+		defer s.cancel()
+
+		resp, err := s.stream.CloseAndReceive()
+		endTime := time.Now()
+
+		code := codes.OK
+		if err != nil {
+			code = codes.Code(uint32(connect.CodeOf(err)))
+		}
+		state := s.client.vu.State()
+		metrics.PushIfNotDone(s.client.vu.Context(), state.Samples, metrics.Sample{
+			TimeSeries: metrics.TimeSeries{
+				Metric: state.BuiltinMetrics.GRPCReqDuration,
+				Tags:   s.p.tagsAndMeta.Tags.With("grpc_status_code", strconv.Itoa(int(code))),
+			},
+			Time:     endTime,
+			Metadata: s.p.tagsAndMeta.Metadata,
+			Value:    metrics.D(endTime.Sub(s.beginTime)),
+		})
+		pushReqFailed(s.client.vu.Context(), state.Samples, s.client.metrics.reqFailed, &s.p.tagsAndMeta, code)
+		if err == nil {
+			pushMessageSize(s.client.vu.Context(), state.Samples, s.client.metrics.respBytes, &s.p.tagsAndMeta, len(resp.Msg.data))
+		}
+
+		callback(func() error {
+			if err != nil {
+				var connectErr *connect.Error
+				if !errors.As(err, &connectErr) {
+					reject(err)
+					return nil
+				}
+				reject(&streamError{
+					Error:        connectErr.Message(),
+					ErrorKind:    errorKind(connectErr),
+					ErrorDetails: s.client.decodeErrorDetails(connectErr.Details()),
+					Status:       codes.Code(uint32(connectErr.Code())),
+				})
+				return nil
+			}
+
+			message, err := s.client.convertResponseMessage(s.md, resp.Msg.data, s.p)
+			if err != nil {
+				reject(err)
+				return nil
+			}
+			resolve(message)
+			return nil
+		})
+	}()
+
+	return promise, nil
+}
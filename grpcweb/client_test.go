@@ -2,29 +2,2755 @@ package grpcweb_test
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/require"
+	"go.k6.io/k6/lib"
+	"go.k6.io/k6/metrics"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 
 	xk6grpcweb "github.com/shota3506/xk6-grpc-web/grpcweb"
 	weatherpb "github.com/shota3506/xk6-grpc-web/grpcweb/internal/grpc/weather"
 )
 
 func TestClient(t *testing.T) {
-	replacer := strings.NewReplacer(
-		"GRPC_WEB_ADDR", "http://"+address,
+	replacements := []string{
+		"GRPC_WEB_ADDR", "http://" + address,
+		"GRPC_ADDR", "http://" + grpcAddress,
+		"GRPC_V1_REFLECTION_ADDR", "http://" + v1ReflectionAddress,
+	}
+
+	dir, err := filepath.Abs("./testdata")
+	require.NoError(t, err)
+	scriptURL := (&url.URL{Scheme: "file", Path: filepath.ToSlash(dir) + "/script.js"}).String()
+
+	// state observed by the checks of the cases
+	var (
+		reflectionsBefore int64
+		contentType       atomic.Value
+		grpcEncoding      atomic.Value
+		logHook           *logtest.Hook
+		hosts             chan string
+		transport         = &countingTransport{}
+		dialer            = &countingDialer{}
 	)
 
 	for _, tt := range []struct {
-		name          string
-		setup         func(*testing.T)
-		initCode      string
+		name  string
+		setup func(*testing.T)
+		// server starts a server for the case, whose URL replaces SERVER_ADDR.
+		server     func(*testing.T) string
+		httpClient *http.Client
+		// scriptURL is the URL of the script which the init code is run as.
+		scriptURL string
+		initCode  string
+		// vuState modifies the state of the VU before the code runs.
+		vuState       func(*lib.State)
 		code          string
 		expectedCalls []string
+		// check asserts what the script cannot observe, such as samples, logs and the state of servers.
+		check func(*testing.T, <-chan metrics.SampleContainer)
 	}{
 		{
-			name: "invoke",
+			name: "invoke",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (!("status" in resp) || resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status: " + resp.status);
+}
+`,
+		},
+		{
+			name: "async invoke",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var resp = client.asyncInvoke("/weather.WeatherService/GetWeather", {}).then(function(resp) {
+  if (resp.status !== grpcweb.StatusOK) {
+    throw new Error("unexpected response status: " + resp.status);
+  }
+}, (err) => {
+  throw new Error("unexpected error: " + err);
+});
+`,
+		},
+		{
+			name: "cancel async invoke",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					select {
+					case <-ctx.Done():
+					case <-time.After(5 * time.Second):
+					}
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var promise = client.asyncInvoke("/weather.WeatherService/GetWeather", {});
+promise.then(function(resp) {
+  call("status: " + resp.status);
+}, (err) => {
+  call("error: " + err);
+});
+promise.cancel();
+`,
+			expectedCalls: []string{
+				`status: 1`,
+			},
+		},
+		{
+			name: "load method info",
+			initCode: `
+let client = new grpcweb.Client();
+const methods = client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+for (const method of methods) {
+  if (method.idempotency_level !== "IDEMPOTENCY_UNKNOWN" || method.idempotent) {
+    throw new Error("unexpected idempotency level: " + method.idempotency_level);
+  }
+  if (method.input_type !== "weather.LocationRequest" || method.output_type !== "weather.WeatherResponse") {
+    throw new Error("unexpected message types: " + method.input_type + " " + method.output_type);
+  }
+}
+`,
+		},
+		{
+			name: "load idempotent method info",
+			initCode: `
+let client = new grpcweb.Client();
+const methods = client.load([], "./testdata/idempotent_weather_service.proto");
+`,
+			code: `
+const method = methods.find((m) => m.full_method === "/weather.WeatherService/GetWeather");
+if (method.idempotency_level !== "NO_SIDE_EFFECTS" || !method.idempotent) {
+  throw new Error("unexpected idempotency level: " + method.idempotency_level);
+}
+`,
+		},
+		{
+			name: "reset loaded methods",
+			initCode: `
+let client = new grpcweb.Client();
+client.reset();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+client.reset();
+`,
+			code: `
+if (client.listMethods().length !== 0) {
+  throw new Error("unexpected methods: " + JSON.stringify(client.listMethods()));
+}
+try {
+  client.methodKind("/weather.WeatherService/GetWeather");
+  throw new Error("method must not be found after reset");
+} catch (e) {
+  if (!String(e).includes("not found")) {
+    throw e;
+  }
+}
+`,
+		},
+		{
+			name: "reset and load again",
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+client.reset();
+const methods = client.load([], "./testdata/idempotent_weather_service.proto");
+`,
+			code: `
+const method = methods.find((m) => m.full_method === "/weather.WeatherService/GetWeather");
+if (!method.idempotent) {
+  throw new Error("unexpected idempotency level: " + method.idempotency_level);
+}
+`,
+		},
+		{
+			name: "load with client import paths",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client({ importPaths: ["./internal/grpc/weather"] });
+client.load([], "weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status: " + resp.status);
+}
+`,
+		},
+		{
+			name: "invoke with client defaults",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client({ protocol: "grpc", timeout: "5s" });
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_ADDR");
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status with the default protocol: " + resp.status + " " + resp.error);
+}
+client.connect("GRPC_WEB_ADDR", { protocol: "grpcweb" });
+resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status with the overridden protocol: " + resp.status + " " + resp.error);
+}
+`,
+		},
+		{
+			name: "invoke with gzip compression",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{Status: strings.Repeat("sunny", 1<<16)}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR", {
+  compression: "gzip",
+});
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status: " + resp.status);
+}
+if (resp.message.status.length !== 5 * 65536) {
+  throw new Error("unexpected response length: " + resp.message.status.length);
+}
+`,
+		},
+		{
+			name: "invoke with grpc protocol",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR", {
+  protocol: "grpc",
+});
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status: " + resp.status);
+}
+`,
+		},
+		{
+			name: "invoke with json codec",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{Temperature: req.Latitude, Status: "sunny"}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_ADDR", { protocol: "grpc", codec: "json" });
+var resp = client.invoke("/weather.WeatherService/GetWeather", { latitude: 1 });
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status: " + resp.status + " " + resp.error);
+}
+if (resp.message.temperature !== 1 || resp.message.status !== "sunny") {
+  throw new Error("unexpected response message: " + JSON.stringify(resp.message));
+}
+`,
+		},
+		{
+			name: "invoke with dial timeout",
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("http://localhost:1", { dialTimeout: "1s" });
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.status !== grpcweb.StatusUnavailable || resp.error_kind !== "transport" || !resp.error.includes("failed to dial")) {
+  throw new Error("unexpected response: " + resp.status + " " + resp.error);
+}
+`,
+		},
+		{
+			name: "invoke with max idle connections",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+for (const value of [-1, 1.5, "10"]) {
+  try {
+    client.connect("GRPC_WEB_ADDR", { maxIdleConns: value });
+    throw new Error("expected an error for " + value);
+  } catch (e) {
+    if (!String(e).includes("maxIdleConns value must be a non-negative integer")) {
+      throw e;
+    }
+  }
+}
+client.connect("GRPC_WEB_ADDR", { maxIdleConns: 10 });
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status: " + resp.status + " " + resp.error);
+}
+`,
+		},
+		{
+			name: "invoke with non-deterministic marshaling",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR", { deterministicMarshal: false });
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status: " + resp.status);
+}
+`,
+		},
+		{
+			name: "invoke with max timeout",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					select {
+					case <-ctx.Done():
+						return nil, ctx.Err()
+					case <-time.After(5 * time.Second):
+						return &weatherpb.WeatherResponse{}, nil
+					}
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client({ maxTimeout: "100ms" });
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var resp = client.invoke("/weather.WeatherService/GetWeather", {}, { timeout: "10s" });
+if (resp.status !== grpcweb.StatusDeadlineExceeded || resp.error_kind !== "deadline") {
+  throw new Error("unexpected response status: " + resp.status);
+}
+`,
+		},
+		{
+			name: "invoke with module default timeout",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					select {
+					case <-ctx.Done():
+						return nil, ctx.Err()
+					case <-time.After(5 * time.Second):
+						return &weatherpb.WeatherResponse{}, nil
+					}
+				})
+			},
+			initCode: `
+grpcweb.setDefaultTimeout("100ms");
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.status !== grpcweb.StatusDeadlineExceeded) {
+  throw new Error("unexpected response status: " + resp.status);
+}
+try {
+  grpcweb.setDefaultTimeout("0s");
+  throw new Error("zero default timeout must fail");
+} catch (e) {
+  if (!String(e).includes("must be positive")) {
+    throw e;
+  }
+}
+`,
+		},
+		{
+			name: "invoke with field mask",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					// a request with latitude gets zero values except for the status
+					if req.Latitude != 0 {
+						return &weatherpb.WeatherResponse{Status: "sunny"}, nil
+					}
+					return &weatherpb.WeatherResponse{Temperature: 20, Humidity: 50, Status: "sunny"}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var resp = client.invoke("/weather.WeatherService/GetWeather", {}, { fieldMask: ["temperature", "status"] });
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status: " + resp.status);
+}
+const keys = Object.keys(resp.message).sort();
+if (JSON.stringify(keys) !== JSON.stringify(["status", "temperature"]) || resp.message.temperature !== 20) {
+  throw new Error("unexpected message: " + JSON.stringify(resp.message));
+}
+// the masked fields with zero values follow emitUnpopulated
+resp = client.invoke("/weather.WeatherService/GetWeather", { latitude: 1 }, { fieldMask: ["temperature", "status"] });
+if (JSON.stringify(resp.message) !== JSON.stringify({ temperature: 0, status: "sunny" })) {
+  throw new Error("unexpected message: " + JSON.stringify(resp.message));
+}
+resp = client.invoke("/weather.WeatherService/GetWeather", { latitude: 1 }, { fieldMask: ["temperature", "status"], emitUnpopulated: false });
+if (JSON.stringify(resp.message) !== JSON.stringify({ status: "sunny" })) {
+  throw new Error("unexpected message: " + JSON.stringify(resp.message));
+}
+resp = client.invoke("/weather.WeatherService/GetWeather", { latitude: 1 }, { fieldMask: ["observed_at"], responseFormat: { useProtoNames: true } });
+if (JSON.stringify(resp.message) !== JSON.stringify({ observed_at: null })) {
+  throw new Error("unexpected message: " + JSON.stringify(resp.message));
+}
+try {
+  client.invoke("/weather.WeatherService/GetWeather", {}, { fieldMask: ["pressure"] });
+  throw new Error("expected an error");
+} catch (e) {
+  if (!String(e).includes("invalid fieldMask")) {
+    throw e;
+  }
+}
+`,
+		},
+		{
+			name: "invoke with trace context",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					md, _ := metadata.FromIncomingContext(ctx)
+					traceparent := md.Get("traceparent")
+					if len(traceparent) != 1 || !regexp.MustCompile(`^00-[0-9a-f]{32}-[0-9a-f]{16}-01$`).MatchString(traceparent[0]) {
+						return nil, status.Errorf(codes.InvalidArgument, "unexpected traceparent: %v", traceparent)
+					}
+					if err := grpc.SetHeader(ctx, metadata.Pairs("x-traceparent", traceparent[0])); err != nil {
+						return nil, err
+					}
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+const traceparents = [];
+for (let i = 0; i < 2; i++) {
+  var resp = client.invoke("/weather.WeatherService/GetWeather", {}, { trace: true });
+  if (resp.status !== grpcweb.StatusOK) {
+    throw new Error("unexpected response status: " + resp.status + " " + resp.error);
+  }
+  traceparents.push(resp.headers["x-traceparent"]);
+}
+if (traceparents[0] === traceparents[1]) {
+  throw new Error("expected fresh trace context: " + JSON.stringify(traceparents));
+}
+`,
+		},
+		{
+			name: "invoke with binary trailers",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					if err := grpc.SetTrailer(ctx, metadata.Pairs("x-custom-bin", string([]byte{1, 2}))); err != nil {
+						return nil, err
+					}
+					st, err := status.New(codes.NotFound, "location not found").WithDetails(&errdetails.ErrorInfo{Reason: "NOT_FOUND"})
+					if err != nil {
+						return nil, err
+					}
+					return nil, st.Err()
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.status !== grpcweb.StatusNotFound || resp.error_kind !== "status") {
+  throw new Error("unexpected response status: " + resp.status + " " + resp.error_kind);
+}
+if (resp.trailers["x-custom-bin"] !== "AQI=") {
+  throw new Error("unexpected custom trailer: " + resp.trailers["x-custom-bin"]);
+}
+if (!resp.trailers["grpc-status-details-bin"]) {
+  throw new Error("missing status details trailer: " + JSON.stringify(resp.trailers));
+}
+`,
+		},
+		{
+			name: "invoke without decoding the response",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{Temperature: 20}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var resp = client.invoke("/weather.WeatherService/GetWeather", {}, { decodeResponse: false });
+if (resp.status !== grpcweb.StatusOK || resp.message !== null) {
+  throw new Error("unexpected response: " + resp.status + " " + JSON.stringify(resp.message));
+}
+`,
+		},
+		{
+			name: "invoke with serialized request",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{Temperature: req.Latitude}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+// latitude (field 1, fixed64) of 1.5
+const buf = new Uint8Array(9);
+buf[0] = 0x09;
+new DataView(buf.buffer).setFloat64(1, 1.5, true);
+var resp = client.invoke("/weather.WeatherService/GetWeather", buf);
+if (resp.status !== grpcweb.StatusOK || resp.message.temperature !== 1.5) {
+  throw new Error("unexpected response: " + resp.status + " " + JSON.stringify(resp.message));
+}
+resp = client.invoke("/weather.WeatherService/GetWeather", buf.buffer);
+if (resp.status !== grpcweb.StatusOK || resp.message.temperature !== 1.5) {
+  throw new Error("unexpected response: " + resp.status + " " + JSON.stringify(resp.message));
+}
+try {
+  client.invoke("/weather.WeatherService/GetWeather", new Uint8Array([0x09]));
+  throw new Error("truncated request must fail");
+} catch (e) {
+  if (!String(e).includes("failed to unmarshal the serialized request")) {
+    throw e;
+  }
+}
+`,
+		},
+		{
+			name: "invoke with raw response",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{Temperature: req.Latitude}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+// latitude (field 1, fixed64) of 1.5
+const req = new Uint8Array(9);
+req[0] = 0x09;
+new DataView(req.buffer).setFloat64(1, 1.5, true);
+
+// temperature (field 1, fixed64) is decoded from the serialized response
+function decode(buf) {
+  if (!(buf instanceof ArrayBuffer) || buf.byteLength !== 9) {
+    throw new Error("unexpected raw response: " + buf);
+  }
+  const view = new DataView(buf);
+  if (view.getUint8(0) !== 0x09) {
+    throw new Error("unexpected tag: " + view.getUint8(0));
+  }
+  return view.getFloat64(1, true);
+}
+
+var resp = client.invoke("/weather.WeatherService/GetWeather", req.buffer, { raw: true });
+call("invoke: " + resp.status + " " + decode(resp.message));
+client.asyncInvoke("/weather.WeatherService/GetWeather", req.buffer, { raw: true }).then((resp) => {
+  call("asyncInvoke: " + resp.status + " " + decode(resp.message));
+});
+`,
+			expectedCalls: []string{
+				`invoke: 0 1.5`,
+				`asyncInvoke: 0 1.5`,
+			},
+		},
+		{
+			name: "invoke with plaintext",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+`,
+			code: `
+client.connect("GRPC_ADDR".replace("http://", "https://"), { protocol: "grpc", plaintext: true, reflect: true });
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.status !== grpcweb.StatusOK || resp.http_version !== "HTTP/2.0") {
+  throw new Error("unexpected response: " + resp.status + " " + resp.http_version);
+}
+try {
+  client.connect("GRPC_ADDR", { plaintext: true, tls: { force: true } });
+  throw new Error("plaintext with tls force must fail");
+} catch (e) {
+  if (!String(e).includes("cannot be enabled together")) {
+    throw e;
+  }
+}
+`,
+		},
+		{
+			name: "invoke with http version",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR", { http1: true });
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.http_version !== "HTTP/1.1") {
+  throw new Error("unexpected http version: " + resp.http_version);
+}
+client.connect("GRPC_ADDR", { protocol: "grpc" });
+resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.http_version !== "HTTP/2.0") {
+  throw new Error("unexpected http version: " + resp.http_version);
+}
+`,
+		},
+		{
+			name: "invoke with duration",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					time.Sleep(50 * time.Millisecond)
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.duration < 50) {
+  throw new Error("unexpected duration: " + resp.duration);
+}
+client.asyncInvoke("/weather.WeatherService/GetWeather", {}).then(function(resp) {
+  if (resp.duration < 50) {
+    throw new Error("unexpected duration: " + resp.duration);
+  }
+}, (err) => {
+  throw new Error("unexpected error: " + err);
+});
+`,
+		},
+		{
+			name: "invoke with grpc-web-text",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{Status: "sunny"}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR", {
+  grpcWebText: true,
+});
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status: " + resp.status);
+}
+if (resp.message.status !== "sunny") {
+  throw new Error("unexpected response message: " + JSON.stringify(resp.message));
+}
+`,
+		},
+		{
+			name: "invoke with per-call grpc-web-text",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{Temperature: req.Latitude}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+for (const grpcWebText of [true, false]) {
+  var resp = client.invoke("/weather.WeatherService/GetWeather", { latitude: 1 }, { grpcWebText: grpcWebText });
+  if (resp.status !== grpcweb.StatusOK) {
+    throw new Error("unexpected response status: " + resp.status + " " + resp.error);
+  }
+  if (resp.message.temperature !== 1) {
+    throw new Error("unexpected response message: " + JSON.stringify(resp.message));
+  }
+}
+`,
+		},
+		{
+			name: "invoke with response format",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{Status: "sunny"}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var resp = client.invoke("/weather.WeatherService/GetWeather", {}, {
+  responseFormat: { emitUnpopulated: false },
+});
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status: " + resp.status);
+}
+if (JSON.stringify(resp.message) !== JSON.stringify({ status: "sunny" })) {
+  throw new Error("unexpected response message: " + JSON.stringify(resp.message));
+}
+`,
+		},
+		{
+			name: "invoke with optional fields",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					if req.Latitude > 0 {
+						return &weatherpb.WeatherResponse{Humidity: 50, Status: "sunny"}, nil
+					}
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./testdata/optional_weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+function keys(resp) {
+  return Object.keys(resp.message).sort().join(",");
+}
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (keys(resp) !== "observedAt,temperature") {
+  throw new Error("unexpected response message: " + JSON.stringify(resp.message));
+}
+resp = client.invoke("/weather.WeatherService/GetWeather", {}, { emitUnpopulated: false });
+if (keys(resp) !== "") {
+  throw new Error("unexpected response message: " + JSON.stringify(resp.message));
+}
+resp = client.invoke("/weather.WeatherService/GetWeather", { latitude: 1 });
+if (keys(resp) !== "humidity,observedAt,status,temperature") {
+  throw new Error("unexpected response message: " + JSON.stringify(resp.message));
+}
+resp = client.invoke("/weather.WeatherService/GetWeather", { latitude: 1 }, { emitUnpopulated: false });
+if (keys(resp) !== "humidity,status") {
+  throw new Error("unexpected response message: " + JSON.stringify(resp.message));
+}
+`,
+		},
+		{
+			name: "invoke with deadline propagated to server",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					deadline, ok := ctx.Deadline()
+					if !ok {
+						return nil, status.Error(codes.InvalidArgument, "no deadline")
+					}
+					if remaining := time.Until(deadline); remaining > 3*time.Second || remaining < time.Second {
+						return nil, status.Errorf(codes.InvalidArgument, "unexpected deadline: %v", remaining)
+					}
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var resp = client.invoke("/weather.WeatherService/GetWeather", {}, { timeout: "3s" });
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status: " + resp.status + " " + resp.error);
+}
+`,
+		},
+		{
+			name: "invoke with protoset",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.loadProtoset("./internal/grpc/weather/weather_service.protoset");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status: " + resp.status);
+}
+`,
+		},
+		{
+			name: "invoke with proto loaded from string",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.loadFromString("weather.proto", ` + "`" + `
+syntax = "proto3";
+
+package weather;
+
+service WeatherService {
+  rpc GetWeather(LocationRequest) returns (WeatherResponse);
+}
+
+message LocationRequest {}
+
+message WeatherResponse {}
+` + "`" + `);
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status: " + resp.status);
+}
+`,
+		},
+		{
+			name: "list methods and services",
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+const methods = client.listMethods().map((m) => m.full_method);
+if (JSON.stringify(methods) !== JSON.stringify(["/weather.WeatherService/GetWeather", "/weather.WeatherService/StreamWeather"])) {
+  throw new Error("unexpected methods: " + JSON.stringify(methods));
+}
+const services = client.listServices();
+if (JSON.stringify(services) !== JSON.stringify(["weather.WeatherService"])) {
+  throw new Error("unexpected services: " + JSON.stringify(services));
+}
+`,
+		},
+		{
+			name: "load returns methods in order",
+			initCode: `
+let client = new grpcweb.Client();
+const methods = client.loadFromString("services.proto", ` + "`" + `
+syntax = "proto3";
+
+package services;
+
+service Zeta {
+  rpc Second(Empty) returns (Empty);
+  rpc First(Empty) returns (Empty);
+}
+
+service Alpha {
+  rpc Only(Empty) returns (Empty);
+}
+
+message Empty {}
+` + "`" + `).map((m) => m.full_method);
+if (JSON.stringify(methods) !== JSON.stringify(["/services.Alpha/Only", "/services.Zeta/First", "/services.Zeta/Second"])) {
+  throw new Error("unexpected methods: " + JSON.stringify(methods));
+}
+`,
+		},
+		{
+			name: "load incrementally with a shared import",
+			initCode: `
+let client = new grpcweb.Client();
+const first = client.load([], "./internal/grpc/weather/weather_service.proto").map((m) => m.full_method);
+const second = client.loadFromString("clock.proto", ` + "`" + `
+syntax = "proto3";
+
+package clock;
+
+import "google/protobuf/timestamp.proto";
+
+service ClockService {
+  rpc Now(NowRequest) returns (google.protobuf.Timestamp);
+}
+
+message NowRequest {}
+` + "`" + `).map((m) => m.full_method);
+const again = client.load([], "./internal/grpc/weather/weather_service.proto");
+if (JSON.stringify(second) !== JSON.stringify(["/clock.ClockService/Now"])) {
+  throw new Error("unexpected methods of the second load: " + JSON.stringify(second));
+}
+if (again.length !== 0) {
+  throw new Error("unexpected methods of the repeated load: " + JSON.stringify(again));
+}
+const methods = client.listMethods().map((m) => m.full_method);
+if (JSON.stringify(methods) !== JSON.stringify(["/clock.ClockService/Now"].concat(first))) {
+  throw new Error("unexpected methods: " + JSON.stringify(methods));
+}
+`,
+		},
+		{
+			name: "method kind",
+			initCode: `
+let client = new grpcweb.Client();
+client.loadFromString("kinds.proto", ` + "`" + `
+syntax = "proto3";
+
+package kinds;
+
+service KindService {
+  rpc Unary(Empty) returns (Empty);
+  rpc ServerStream(Empty) returns (stream Empty);
+  rpc ClientStream(stream Empty) returns (Empty);
+  rpc Bidi(stream Empty) returns (stream Empty);
+}
+
+message Empty {}
+` + "`" + `);
+`,
+			code: `
+const kinds = ["Unary", "ServerStream", "ClientStream", "Bidi"].map((m) => client.methodKind("/kinds.KindService/" + m));
+if (JSON.stringify(kinds) !== JSON.stringify(["unary", "serverStream", "clientStream", "bidi"])) {
+  throw new Error("unexpected method kinds: " + JSON.stringify(kinds));
+}
+try {
+  client.methodKind("/kinds.KindService/Unknown");
+  throw new Error("expected an error");
+} catch (e) {
+  if (!String(e).includes("not found")) {
+    throw e;
+  }
+}
+`,
+		},
+		{
+			name: "new request",
+			initCode: `
+let client = new grpcweb.Client();
+client.loadFromString("orders.proto", ` + "`" + `
+syntax = "proto3";
+
+package orders;
+
+service OrderService {
+  rpc Create(Order) returns (Order);
+}
+
+message Order {
+  string id = 1;
+  Customer customer = 2;
+  repeated Item items = 3;
+  Order parent = 4;
+}
+
+message Customer {
+  string name = 1;
+  int32 age = 2;
+}
+
+message Item {
+  string sku = 1;
+}
+` + "`" + `);
+`,
+			code: `
+const req = client.newRequest("orders.OrderService/Create");
+if (req.id !== "" || req.customer.name !== "" || req.customer.age !== 0 || req.items.length !== 0 || req.parent !== null) {
+  throw new Error("unexpected request: " + JSON.stringify(req));
+}
+try {
+  client.newRequest("/orders.OrderService/Delete");
+  throw new Error("expected an error");
+} catch (e) {
+  if (!String(e).includes("not found")) {
+    throw e;
+  }
+}
+`,
+		},
+		{
+			name: "invoke with request validation",
+			initCode: `
+let client = new grpcweb.Client();
+client.loadFromString("orders.proto", ` + "`" + `
+syntax = "proto2";
+
+package orders;
+
+service OrderService {
+  rpc Create(Order) returns (Order);
+}
+
+message Order {
+  required string id = 1;
+  optional Customer customer = 2;
+  repeated Item items = 3;
+}
+
+message Customer {
+  optional string name = 1;
+  optional int32 age = 2;
+}
+
+message Item {
+  optional string sku = 1;
+}
+` + "`" + `);
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+const cases = [
+  [{ id: "1", customer: { age: "ten", nmae: "x" } }, "unknown request field customer.nmae"],
+  [{ id: "1", customer: { age: true } }, "invalid request field customer.age: expected number"],
+  [{ id: "1", items: [{ sku: "a" }, { sku: 1 }] }, "invalid request field items[1].sku: expected string"],
+  [{ customer: {} }, "missing required request field id"],
+];
+for (const [req, expected] of cases) {
+  try {
+    client.invoke("/orders.OrderService/Create", req, { validate: true });
+    throw new Error("expected an error for " + JSON.stringify(req));
+  } catch (e) {
+    if (!String(e).includes(expected)) {
+      throw e;
+    }
+  }
+}
+`,
+		},
+		{
+			name: "descriptor set",
+			initCode: `
+let client = new grpcweb.Client();
+let empty = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+var buf = client.descriptorSet();
+if (!(buf instanceof ArrayBuffer) || buf.byteLength === 0) {
+  throw new Error("unexpected descriptor set: " + buf);
+}
+try {
+  empty.descriptorSet();
+  throw new Error("expected no descriptors error");
+} catch (e) {
+  if (!String(e).includes("no descriptors loaded")) {
+    throw e;
+  }
+}
+`,
+		},
+		{
+			name: "invoke with error details",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					st, err := status.New(codes.InvalidArgument, "invalid location").WithDetails(&errdetails.BadRequest{
+						FieldViolations: []*errdetails.BadRequest_FieldViolation{
+							{Field: "latitude", Description: "out of range"},
+						},
+					})
+					if err != nil {
+						return nil, err
+					}
+					return nil, st.Err()
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.status !== grpcweb.StatusInvalidArgument) {
+  throw new Error("unexpected response status: " + resp.status);
+}
+if (grpcweb.statusText(resp.status) !== "InvalidArgument") {
+  throw new Error("unexpected status text: " + grpcweb.statusText(resp.status));
+}
+if (grpcweb.statusCodes.InvalidArgument !== resp.status || Object.keys(grpcweb.statusCodes).length !== 17) {
+  throw new Error("unexpected status codes: " + JSON.stringify(grpcweb.statusCodes));
+}
+const detail = resp.error_details[0];
+if (detail.type !== "google.rpc.BadRequest" || detail.value.fieldViolations[0].field !== "latitude") {
+  throw new Error("unexpected error details: " + JSON.stringify(resp.error_details));
+}
+`,
+		},
+		{
+			name: "invoke with custom error details",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					// custom.QuotaError{remaining: 5, reason: "daily"} has no Go type, so it is encoded by hand
+					value := protowire.AppendTag(nil, 1, protowire.VarintType)
+					value = protowire.AppendVarint(value, 5)
+					value = protowire.AppendTag(value, 2, protowire.BytesType)
+					value = protowire.AppendString(value, "daily")
+					return nil, status.ErrorProto(&spb.Status{
+						Code:    int32(codes.ResourceExhausted),
+						Message: "quota exceeded",
+						Details: []*anypb.Any{
+							{TypeUrl: "type.googleapis.com/custom.QuotaError", Value: value},
+						},
+					})
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+client.loadFromString("custom_error.proto", ` + "`" + `
+syntax = "proto3";
+package custom;
+message QuotaError {
+  int32 remaining = 1;
+  string reason = 2;
+}
+` + "`" + `);
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.status !== grpcweb.StatusResourceExhausted) {
+  throw new Error("unexpected response status: " + resp.status);
+}
+const detail = resp.error_details[0];
+if (detail.type !== "custom.QuotaError" || detail.value.remaining !== 5 || detail.value.reason !== "daily") {
+  throw new Error("unexpected error details: " + JSON.stringify(resp.error_details));
+}
+`,
+		},
+		{
+			name: "invoke with retry",
+			setup: func(t *testing.T) {
+				var calls atomic.Int64
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					if calls.Add(1) < 3 {
+						return nil, status.Error(codes.Unavailable, "unavailable")
+					}
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./testdata/idempotent_weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var resp = client.invoke("/weather.WeatherService/GetWeather", {}, {
+  retry: { max: 3, backoff: "10ms", codes: ["Unavailable"] },
+});
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status: " + resp.status);
+}
+`,
+			check: func(t *testing.T, samples <-chan metrics.SampleContainer) {
+				// the failed attempts are recorded in grpc_req_duration, and the call once in grpc_req_failed
+				var durationCodes, failedCodes []string
+				for _, container := range metrics.GetBufferedSamples(samples) {
+					for _, sample := range container.GetSamples() {
+						statusCode, _ := sample.Tags.Get("grpc_status_code")
+						switch sample.Metric.Name {
+						case metrics.GRPCReqDurationName:
+							durationCodes = append(durationCodes, statusCode)
+						case "grpc_req_failed":
+							_, ok := sample.Tags.Get("status")
+							require.False(t, ok)
+							failedCodes = append(failedCodes, statusCode)
+						}
+					}
+				}
+				require.Equal(t, []string{"14", "14", "0"}, durationCodes)
+				require.Equal(t, []string{"0"}, failedCodes)
+			},
+		},
+		{
+			name: "invoke with globally registered service",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{Temperature: 20}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+var methods = client.loadGlobal("weather.WeatherService");
+if (methods.length === 0) {
+  throw new Error("no methods loaded");
+}
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status: " + resp.status);
+}
+if (resp.message.temperature !== 20) {
+  throw new Error("unexpected temperature: " + resp.message.temperature);
+}
+`,
+		},
+		{
+			name: "load unregistered global service",
+			initCode: `
+let client = new grpcweb.Client();
+try {
+  client.loadGlobal("weather.UnknownService");
+  throw new Error("expected an error");
+} catch (e) {
+  if (!String(e).includes("is not registered globally")) {
+    throw e;
+  }
+}
+`,
+		},
+		{
+			name: "invoke with shared deadline",
+			setup: func(t *testing.T) {
+				var calls atomic.Int64
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					if calls.Add(1) == 1 {
+						time.Sleep(200 * time.Millisecond)
+						return &weatherpb.WeatherResponse{}, nil
+					}
+					<-ctx.Done()
+					return nil, ctx.Err()
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+const deadline = Date.now() + 500;
+var first = client.invoke("/weather.WeatherService/GetWeather", {}, { deadline: deadline, timeout: "10s" });
+if (first.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected first response status: " + first.status);
+}
+const begin = Date.now();
+var second = client.invoke("/weather.WeatherService/GetWeather", {}, { deadline: new Date(deadline).toISOString(), timeout: "10s" });
+if (second.status !== grpcweb.StatusDeadlineExceeded) {
+  throw new Error("unexpected second response status: " + second.status);
+}
+if (Date.now() - begin >= 500) {
+  throw new Error("second call must fail at the shared deadline");
+}
+`,
+		},
+		{
+			name: "invoke with past deadline",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var resp = client.invoke("/weather.WeatherService/GetWeather", {}, { deadline: Date.now() - 1000 });
+if (resp.status !== grpcweb.StatusDeadlineExceeded) {
+  throw new Error("unexpected response status: " + resp.status);
+}
+`,
+		},
+		{
+			name: "invoke with hedge",
+			setup: func(t *testing.T) {
+				var calls atomic.Int64
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					if calls.Add(1) == 1 {
+						<-ctx.Done()
+						return nil, ctx.Err()
+					}
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./testdata/idempotent_weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var resp = client.invoke("/weather.WeatherService/GetWeather", {}, {
+  hedge: { delay: "50ms", max: 2 },
+});
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status: " + resp.status);
+}
+if (resp.hedge_attempt !== 2) {
+  throw new Error("unexpected hedge attempt: " + resp.hedge_attempt);
+}
+`,
+		},
+		{
+			name: "invoke with hedge of non-idempotent method",
+			setup: func(t *testing.T) {
+				var calls atomic.Int64
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					if calls.Add(1) == 1 {
+						time.Sleep(100 * time.Millisecond)
+					}
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var resp = client.invoke("/weather.WeatherService/GetWeather", {}, {
+  hedge: { delay: "10ms", max: 3 },
+});
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status: " + resp.status);
+}
+if (resp.hedge_attempt !== 1) {
+  throw new Error("unexpected hedge attempt: " + resp.hedge_attempt);
+}
+`,
+		},
+		{
+			name: "invoke with invalid hedge",
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./testdata/idempotent_weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+try {
+  client.invoke("/weather.WeatherService/GetWeather", {}, { hedge: { max: 2 } });
+  throw new Error("expected an error");
+} catch (e) {
+  if (!String(e).includes("hedge delay is required")) {
+    throw e;
+  }
+}
+`,
+		},
+		{
+			name: "invoke with retry of non-idempotent method",
+			setup: func(t *testing.T) {
+				var calls atomic.Int64
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					if calls.Add(1) < 3 {
+						return nil, status.Error(codes.Unavailable, "unavailable")
+					}
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var resp = client.invoke("/weather.WeatherService/GetWeather", {}, {
+  retry: { max: 3, backoff: "10ms", codes: ["Unavailable"] },
+});
+if (resp.status !== grpcweb.StatusUnavailable) {
+  throw new Error("unexpected response status: " + resp.status);
+}
+`,
+		},
+		{
+			name: "invoke after close and reconnect",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.close();
+client.connect("GRPC_WEB_ADDR");
+client.invoke("/weather.WeatherService/GetWeather", {});
+client.close();
+try {
+  client.invoke("/weather.WeatherService/GetWeather", {});
+  throw new Error("invoke after close must fail");
+} catch (e) {
+  if (!String(e).includes("connect must be called first")) {
+    throw e;
+  }
+}
+client.connect("GRPC_WEB_ADDR");
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status: " + resp.status);
+}
+`,
+		},
+		{
+			name: "invoke many",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					if req.Latitude < 0 {
+						return nil, status.Error(codes.InvalidArgument, "invalid latitude")
+					}
+					return &weatherpb.WeatherResponse{Temperature: req.Latitude}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var resps = client.invokeMany("/weather.WeatherService/GetWeather", [
+  { latitude: 1 },
+  { latitude: -1 },
+  { latitude: 3 },
+], {}, 2);
+if (resps.map((r) => r.status).join(",") !== [grpcweb.StatusOK, grpcweb.StatusInvalidArgument, grpcweb.StatusOK].join(",")) {
+  throw new Error("unexpected response statuses: " + JSON.stringify(resps));
+}
+if (resps[0].message.temperature !== 1 || resps[2].message.temperature !== 3) {
+  throw new Error("unexpected response order: " + JSON.stringify(resps));
+}
+try {
+  client.invokeMany("/weather.WeatherService/GetWeather", [{ latitude: 1 }], {}, 0);
+  throw new Error("expected an error");
+} catch (e) {
+  if (!String(e).includes("concurrency value must be a positive integer")) {
+    throw e;
+  }
+}
+`,
+		},
+		{
+			name: "invoke with well-known types",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					if !req.Time.AsTime().Equal(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)) {
+						return nil, status.Errorf(codes.InvalidArgument, "unexpected time: %v", req.Time.AsTime())
+					}
+					return &weatherpb.WeatherResponse{ObservedAt: req.Time}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.loadProtoset("./internal/grpc/weather/weather_service.protoset");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var resp = client.invoke("/weather.WeatherService/GetWeather", { time: "2023-01-01T00:00:00Z" });
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status: " + resp.status + " " + resp.error);
+}
+if (resp.message.observedAt !== "2023-01-01T00:00:00Z") {
+  throw new Error("unexpected response message: " + JSON.stringify(resp.message));
+}
+`,
+		},
+		{
+			name: "invoke with headers and trailers",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					grpc.SetHeader(ctx, metadata.Pairs("x-weather-source", "stub"))
+					grpc.SetTrailer(ctx, metadata.Pairs("x-weather-cache", "miss"))
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.headers["x-weather-source"] !== "stub") {
+  throw new Error("unexpected response headers: " + JSON.stringify(resp.headers));
+}
+if (resp.trailers["x-weather-cache"] !== "miss") {
+  throw new Error("unexpected response trailers: " + JSON.stringify(resp.trailers));
+}
+if (resp.header["X-Weather-Source"][0] !== "stub") {
+  throw new Error("unexpected raw response headers: " + JSON.stringify(resp.header));
+}
+`,
+		},
+		{
+			name: "invoke with normalized method",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+for (const method of ["weather.WeatherService/GetWeather", "weather.WeatherService.GetWeather", "/weather.WeatherService.GetWeather"]) {
+  var resp = client.invoke(method, {});
+  if (resp.status !== grpcweb.StatusOK) {
+    throw new Error("unexpected response status of " + method + ": " + resp.status);
+  }
+}
+try {
+  client.invoke("/weather.WeatherService/GetWether", {});
+  throw new Error("expected method not found error");
+} catch (e) {
+  if (!String(e).includes("did you mean /weather.WeatherService/GetWeather")) {
+    throw e;
+  }
+}
+`,
+		},
+		{
+			name: "invoke with user agent",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					md, _ := metadata.FromIncomingContext(ctx)
+					return &weatherpb.WeatherResponse{Status: strings.Join(md.Get("user-agent"), ",")}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+let custom = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+custom.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+custom.connect("GRPC_WEB_ADDR", { userAgent: "load-test/1.0" });
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+call("default: " + /^xk6-grpc-web\/\S+$/.test(resp.message.status));
+resp = custom.invoke("/weather.WeatherService/GetWeather", {});
+call("connect: " + resp.message.status);
+resp = custom.invoke("/weather.WeatherService/GetWeather", {}, { metadata: { "user-agent": "per-call/2.0" } });
+call("call: " + resp.message.status);
+`,
+			expectedCalls: []string{
+				`default: true`,
+				`connect: load-test/1.0`,
+				`call: per-call/2.0`,
+			},
+		},
+		{
+			name: "invoke with repeated metadata values",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					md, _ := metadata.FromIncomingContext(ctx)
+					if got := md.Get("x-tenant"); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+						return nil, status.Errorf(codes.InvalidArgument, "unexpected tenants: %v", got)
+					}
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var resp = client.invoke("/weather.WeatherService/GetWeather", {}, { metadata: { "x-tenant": ["a", "b"] } });
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status: " + resp.status + " " + resp.error);
+}
+try {
+  client.invoke("/weather.WeatherService/GetWeather", {}, { metadata: { "x-tenant": ["a", 1] } });
+  throw new Error("expected an error");
+} catch (e) {
+  if (!String(e).includes("x-tenant values must be strings")) {
+    throw e;
+  }
+}
+`,
+		},
+		{
+			name: "invoke with metadata provider",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					md, _ := metadata.FromIncomingContext(ctx)
+					return nil, status.Errorf(codes.Unauthenticated, "%s %s", md.Get("authorization"), md.Get("x-tenant"))
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+let iteration = 0;
+client.setMetadataProvider((method) => {
+  iteration++;
+  return { authorization: "token-" + iteration, "x-tenant": method };
+});
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.error !== "[token-1] [/weather.WeatherService/GetWeather]") {
+  throw new Error("unexpected metadata: " + resp.error);
+}
+resp = client.invoke("/weather.WeatherService/GetWeather", {}, { metadata: { "X-Tenant": "override" } });
+if (resp.error !== "[token-2] [override]") {
+  throw new Error("unexpected metadata: " + resp.error);
+}
+`,
+		},
+		{
+			name: "invoke with request interceptor",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					md, _ := metadata.FromIncomingContext(ctx)
+					if got := md.Get("x-signature"); len(got) != 1 || got[0] != "/weather.WeatherService/GetWeather:9" {
+						return nil, status.Errorf(codes.Unauthenticated, "unexpected signature: %v", got)
+					}
+					if got := md.Get("x-tenant"); len(got) != 2 {
+						return nil, status.Errorf(codes.InvalidArgument, "unexpected tenants: %v", got)
+					}
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+client.setRequestInterceptor((method, headers, body) => {
+  if (headers["x-tenant"].length !== 2) {
+    throw new Error("unexpected headers: " + JSON.stringify(headers));
+  }
+  headers["x-signature"] = method + ":" + body.byteLength;
+  return headers;
+});
+const params = { metadata: { "x-tenant": ["a", "b"] } };
+var resp = client.invoke("/weather.WeatherService/GetWeather", { latitude: 1 }, params);
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status: " + resp.status + " " + resp.error);
+}
+// the headers returned unchanged are kept, so the signature of the metadata is sent
+client.setRequestInterceptor((method, headers) => headers);
+resp = client.invoke("/weather.WeatherService/GetWeather", { latitude: 1 }, {
+  metadata: { "x-tenant": ["a", "b"], "x-signature": "/weather.WeatherService/GetWeather:9" },
+});
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status: " + resp.status + " " + resp.error);
+}
+`,
+		},
+		{
+			name: "invoke with reflection",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR", {
+  reflect: true,
+});
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status: " + resp.status);
+}
+`,
+		},
+		{
+			name: "reflect after connect",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+`,
+			code: `
+try {
+  client.reflect();
+  throw new Error("expected no connection error");
+} catch (e) {
+  if (!String(e).includes("connect must be called first")) {
+    throw e;
+  }
+}
+client.connect("GRPC_WEB_ADDR");
+const methods = client.reflect({ address: "GRPC_V1_REFLECTION_ADDR", reflectProtocol: "grpc" }).map((m) => m.full_method);
+if (!methods.includes("/weather.WeatherService/GetWeather")) {
+  throw new Error("unexpected methods: " + JSON.stringify(methods));
+}
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status: " + resp.status);
+}
+`,
+		},
+		{
+			name: "invoke with reflection of allowed services",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+`,
+			code: `
+try {
+  client.connect("GRPC_ADDR", { protocol: "grpc", reflect: true, reflectServices: ["weather.UnknownService"] });
+  throw new Error("expected an error");
+} catch (e) {
+  if (!String(e).includes("service weather.UnknownService is not advertised")) {
+    throw e;
+  }
+}
+client.connect("GRPC_ADDR", { protocol: "grpc", reflect: true, reflectServices: ["weather.WeatherService"] });
+const services = client.listServices();
+if (JSON.stringify(services) !== JSON.stringify(["weather.WeatherService"])) {
+  throw new Error("unexpected services: " + JSON.stringify(services));
+}
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status: " + resp.status);
+}
+`,
+		},
+		{
+			name: "invoke with v1 server reflection",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+`,
+			code: `
+try {
+  client.connect("GRPC_V1_REFLECTION_ADDR", { protocol: "grpc", reflect: true, reflectVersion: "v1alpha" });
+  throw new Error("expected server reflection error");
+} catch (e) {
+  if (!String(e).includes("grpc.reflection.v1alpha is not implemented")) {
+    throw e;
+  }
+}
+client.connect("GRPC_V1_REFLECTION_ADDR", { protocol: "grpc", reflect: true, reflectVersion: "v1" });
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status: " + resp.status);
+}
+`,
+		},
+		{
+			name: "server streaming",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetStreamWeather(t, func(req *weatherpb.LocationRequest, stream weatherpb.WeatherService_StreamWeatherServer) error {
+					for range 3 {
+						stream.Send(&weatherpb.WeatherResponse{})
+					}
+					return nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+const stream = client.stream("/weather.WeatherService/StreamWeather", {});
+stream.on("data", (data) => {
+  call("data")
+});
+stream.on("error", (e) => {
+  call("error: " + e)
+});
+stream.on("end", () => {
+  call("end")
+  client.close();
+});
+`,
+			expectedCalls: []string{
+				`data`,
+				`data`,
+				`data`,
+				`end`,
+			},
+		},
+		{
+			name: "server streaming continuing on handler error",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetStreamWeather(t, func(req *weatherpb.LocationRequest, stream weatherpb.WeatherService_StreamWeatherServer) error {
+					for range 3 {
+						stream.Send(&weatherpb.WeatherResponse{})
+					}
+					return nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+const stream = client.stream("/weather.WeatherService/StreamWeather", {}, { continueOnHandlerError: true });
+stream.on("data", (data) => {
+  call("data")
+  throw new Error("assertion failed");
+});
+stream.on("error", (e) => {
+  call("error: " + e)
+});
+stream.on("end", () => {
+  call("end")
+  client.close();
+});
+`,
+			expectedCalls: []string{
+				`data`,
+				`data`,
+				`data`,
+				`end`,
+			},
+		},
+		{
+			name: "server streaming with max messages",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetStreamWeather(t, func(req *weatherpb.LocationRequest, stream weatherpb.WeatherService_StreamWeatherServer) error {
+					for {
+						if err := stream.Send(&weatherpb.WeatherResponse{}); err != nil {
+							return err
+						}
+						select {
+						case <-stream.Context().Done():
+							return stream.Context().Err()
+						case <-time.After(10 * time.Millisecond):
+						}
+					}
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+const stream = client.stream("/weather.WeatherService/StreamWeather", {}, { maxMessages: 2 });
+stream.on("data", (data) => {
+  call("data")
+});
+stream.on("error", (e) => {
+  call("error: " + e)
+});
+stream.on("end", () => {
+  call("end")
+  client.close();
+});
+`,
+			expectedCalls: []string{
+				`data`,
+				`data`,
+				`end`,
+			},
+		},
+		{
+			name: "server streaming with consume delay",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetStreamWeather(t, func(req *weatherpb.LocationRequest, stream weatherpb.WeatherService_StreamWeatherServer) error {
+					for range 3 {
+						stream.Send(&weatherpb.WeatherResponse{})
+					}
+					return nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+const begin = Date.now();
+const stream = client.stream("/weather.WeatherService/StreamWeather", {}, { consumeDelay: "100ms", consumeJitter: "10ms" });
+stream.on("data", (data) => {
+  call("data")
+});
+stream.on("end", () => {
+  if (Date.now() - begin < 200) {
+    throw new Error("messages were received without delay");
+  }
+  call("end")
+  client.close();
+});
+`,
+			expectedCalls: []string{
+				`data`,
+				`data`,
+				`data`,
+				`end`,
+			},
+		},
+		{
+			name: "server streaming canceled by close",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetStreamWeather(t, func(req *weatherpb.LocationRequest, stream weatherpb.WeatherService_StreamWeatherServer) error {
+					if err := stream.Send(&weatherpb.WeatherResponse{}); err != nil {
+						return err
+					}
+					<-stream.Context().Done()
+					return stream.Context().Err()
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+const stream = client.stream("/weather.WeatherService/StreamWeather", {});
+stream.on("data", (data) => {
+  call("data")
+  client.close();
+});
+stream.on("error", (e) => {
+  call("error: " + e)
+});
+stream.on("end", () => {
+  call("end")
+});
+`,
+			expectedCalls: []string{
+				`data`,
+				`end`,
+			},
+		},
+		{
+			name: "server streaming with undecodable message",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetStreamWeather(t, func(req *weatherpb.LocationRequest, stream weatherpb.WeatherService_StreamWeatherServer) error {
+					stream.Send(&weatherpb.WeatherResponse{Status: "abc"})
+					stream.Send(&weatherpb.WeatherResponse{})
+					return nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+// status is declared as a message, so the string sent by the server cannot be decoded
+client.loadFromString("weather.proto", ` + "`" + `
+syntax = "proto3";
+
+package weather;
+
+service WeatherService {
+  rpc StreamWeather(LocationRequest) returns (stream WeatherResponse);
+}
+
+message LocationRequest {}
+
+message Status {}
+
+message WeatherResponse {
+  Status status = 3;
+}
+` + "`" + `);
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+const stream = client.stream("/weather.WeatherService/StreamWeather", {});
+stream.on("data", (data) => {
+  call("data")
+});
+stream.on("error", (e) => {
+  call("error: " + e.status)
+});
+stream.on("end", () => {
+  call("end")
+  client.close();
+});
+`,
+			expectedCalls: []string{
+				`error: 13`,
+				`data`,
+				`end`,
+			},
+		},
+		{
+			name: "server streaming with undecodable message and done",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetStreamWeather(t, func(req *weatherpb.LocationRequest, stream weatherpb.WeatherService_StreamWeatherServer) error {
+					stream.Send(&weatherpb.WeatherResponse{Status: "abc"})
+					stream.Send(&weatherpb.WeatherResponse{})
+					return nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+// status is declared as a message, so the string sent by the server cannot be decoded
+client.loadFromString("weather.proto", ` + "`" + `
+syntax = "proto3";
+
+package weather;
+
+service WeatherService {
+  rpc StreamWeather(LocationRequest) returns (stream WeatherResponse);
+}
+
+message LocationRequest {}
+
+message Status {}
+
+message WeatherResponse {
+  Status status = 3;
+}
+` + "`" + `);
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+const stream = client.stream("/weather.WeatherService/StreamWeather", {});
+stream.on("error", (e) => {
+  call("error: " + e.status)
+});
+(async () => {
+  try {
+    const result = await stream.next();
+    call("next: " + result.done);
+    await stream.done();
+    call("done");
+  } catch (e) {
+    call("rejected: " + e.status);
+  }
+  client.close();
+})();
+`,
+			// the undecodable message only emits the error event, and the stream goes on
+			expectedCalls: []string{
+				`error: 13`,
+				`next: false`,
+				`done`,
+			},
+		},
+		{
+			name: "server streaming with error status",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetStreamWeather(t, func(req *weatherpb.LocationRequest, stream weatherpb.WeatherService_StreamWeatherServer) error {
+					for range 2 {
+						stream.Send(&weatherpb.WeatherResponse{})
+					}
+					return status.Error(codes.FailedPrecondition, "station offline")
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+const stream = client.stream("/weather.WeatherService/StreamWeather", {});
+stream.on("data", (data) => {
+  call("data")
+});
+stream.on("error", (e) => {
+  call("error: " + e.status + " " + e.error)
+});
+stream.on("end", () => {
+  call("end")
+  client.close();
+});
+`,
+			expectedCalls: []string{
+				`data`,
+				`data`,
+				`error: 9 station offline`,
+				`end`,
+			},
+		},
+		{
+			name: "server streaming with iterator",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetStreamWeather(t, func(req *weatherpb.LocationRequest, stream weatherpb.WeatherService_StreamWeatherServer) error {
+					for i := range 3 {
+						stream.Send(&weatherpb.WeatherResponse{Temperature: float64(i)})
+					}
+					return nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+const stream = client.stream("/weather.WeatherService/StreamWeather", {});
+stream.on("end", () => {
+  call("end")
+});
+(async () => {
+  while (true) {
+    const result = await stream.next();
+    if (result.done) {
+      break;
+    }
+    call("data: " + result.value.temperature)
+  }
+  call("done")
+  client.close();
+})();
+`,
+			expectedCalls: []string{
+				`data: 0`,
+				`data: 1`,
+				`data: 2`,
+				`end`,
+				`done`,
+			},
+		},
+		{
+			name: "server streaming with connect timeout",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetStreamWeather(t, func(req *weatherpb.LocationRequest, stream weatherpb.WeatherService_StreamWeatherServer) error {
+					for range 3 {
+						time.Sleep(150 * time.Millisecond)
+						if err := stream.Send(&weatherpb.WeatherResponse{}); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR", { timeout: "200ms" });
+(async () => {
+  const limited = client.stream("/weather.WeatherService/StreamWeather", {});
+  limited.on("data", () => {
+    call("limited data");
+  });
+  try {
+    await limited.done();
+  } catch (e) {
+    call("limited error: " + e.status);
+  }
+
+  // a zero timeout opts the stream out of the timeout of connect
+  const unlimited = client.stream("/weather.WeatherService/StreamWeather", {}, { timeout: 0 });
+  unlimited.on("data", () => {
+    call("unlimited data");
+  });
+  await unlimited.done();
+  call("unlimited end");
+  client.close();
+})();
+`,
+			expectedCalls: []string{
+				`limited data`,
+				`limited error: 4`,
+				`unlimited data`,
+				`unlimited data`,
+				`unlimited data`,
+				`unlimited end`,
+			},
+		},
+		{
+			// the runtime has neither Symbol.asyncIterator nor for await, so streams are iterated with next
+			name: "server streaming without for await",
+			initCode: `
+let client = new grpcweb.Client();
+`,
+			code: `
+call("asyncIterator: " + typeof Symbol.asyncIterator);
+try {
+  new Function("return (async (stream) => { for await (const message of stream) {} })");
+  call("for await: supported");
+} catch (e) {
+  call("for await: " + e.name);
+}
+`,
+			expectedCalls: []string{
+				`asyncIterator: undefined`,
+				`for await: SyntaxError`,
+			},
+		},
+		{
+			name: "server streaming with iterator after done",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetStreamWeather(t, func(req *weatherpb.LocationRequest, stream weatherpb.WeatherService_StreamWeatherServer) error {
+					for i := range 3 {
+						stream.Send(&weatherpb.WeatherResponse{Temperature: float64(i)})
+					}
+					return nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+const stream = client.stream("/weather.WeatherService/StreamWeather", {});
+(async () => {
+  // the messages received before the first next call are buffered
+  await stream.done();
+  call("done")
+  while (true) {
+    const result = await stream.next();
+    if (result.done) {
+      break;
+    }
+    call("data: " + result.value.temperature)
+  }
+  client.close();
+})();
+`,
+			expectedCalls: []string{
+				`done`,
+				`data: 0`,
+				`data: 1`,
+				`data: 2`,
+			},
+		},
+		{
+			name: "server streaming with open event",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetStreamWeather(t, func(req *weatherpb.LocationRequest, stream weatherpb.WeatherService_StreamWeatherServer) error {
+					if err := stream.SendHeader(metadata.Pairs("x-station", "tokyo")); err != nil {
+						return err
+					}
+					return stream.Send(&weatherpb.WeatherResponse{})
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+const begin = Date.now();
+const stream = client.stream("/weather.WeatherService/StreamWeather", {});
+stream.on("open", (e) => {
+  call("open: " + e.headers["x-station"] + " " + (e.time >= begin));
+});
+stream.on("data", () => {
+  call("data");
+});
+stream.on("end", () => {
+  call("end");
+  client.close();
+});
+`,
+			expectedCalls: []string{
+				`open: tokyo true`,
+				`data`,
+				`end`,
+			},
+		},
+		{
+			name: "server streaming with headers in data",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetStreamWeather(t, func(req *weatherpb.LocationRequest, stream weatherpb.WeatherService_StreamWeatherServer) error {
+					if err := stream.SendHeader(metadata.Pairs("x-station", "tokyo")); err != nil {
+						return err
+					}
+					return stream.Send(&weatherpb.WeatherResponse{Temperature: 20})
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+const stream = client.stream("/weather.WeatherService/StreamWeather", {}, { includeHeadersInData: true });
+stream.on("data", (data) => {
+  call("data: " + data.message.temperature + " " + data.headers["x-station"]);
+});
+stream.on("end", () => {
+  call("end");
+  client.close();
+});
+`,
+			expectedCalls: []string{
+				`data: 20 tokyo`,
+				`end`,
+			},
+		},
+		{
+			name: "server streaming with headers timeout",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetStreamWeather(t, func(req *weatherpb.LocationRequest, stream weatherpb.WeatherService_StreamWeatherServer) error {
+					if err := stream.SendHeader(metadata.Pairs("x-station", "tokyo")); err != nil {
+						return err
+					}
+					return stream.Send(&weatherpb.WeatherResponse{})
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+const stream = client.stream("/weather.WeatherService/StreamWeather", {}, { headersTimeout: "5s" });
+call("headers: " + stream.headers["x-station"] + " " + stream.header["X-Station"][0]);
+stream.on("end", () => {
+  client.close();
+});
+`,
+			expectedCalls: []string{
+				`headers: tokyo tokyo`,
+			},
+		},
+		{
+			name: "server streaming with done",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetStreamWeather(t, func(req *weatherpb.LocationRequest, stream weatherpb.WeatherService_StreamWeatherServer) error {
+					stream.Send(&weatherpb.WeatherResponse{})
+					return status.Error(codes.Unavailable, "station offline")
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+const stream = client.stream("/weather.WeatherService/StreamWeather", {});
+stream.on("data", (data) => {
+  call("data")
+});
+(async () => {
+  try {
+    await stream.done();
+    call("done");
+  } catch (e) {
+    call("error: " + e.status);
+  }
+  client.close();
+})();
+`,
+			expectedCalls: []string{
+				`data`,
+				`error: 14`,
+			},
+		},
+		{
+			name: "collect stream",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetStreamWeather(t, func(req *weatherpb.LocationRequest, stream weatherpb.WeatherService_StreamWeatherServer) error {
+					for i := range 3 {
+						stream.Send(&weatherpb.WeatherResponse{Temperature: float64(i)})
+					}
+					return nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+(async () => {
+  const messages = await client.collectStream("/weather.WeatherService/StreamWeather", {});
+  call("messages: " + messages.map((m) => m.temperature).join(","));
+  const limited = await client.collectStream("/weather.WeatherService/StreamWeather", {}, { maxMessages: 2 });
+  call("limited: " + limited.length);
+  client.close();
+})();
+`,
+			expectedCalls: []string{
+				`messages: 0,1,2`,
+				`limited: 2`,
+			},
+		},
+		{
+			name: "collect stream with error",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetStreamWeather(t, func(req *weatherpb.LocationRequest, stream weatherpb.WeatherService_StreamWeatherServer) error {
+					stream.Send(&weatherpb.WeatherResponse{})
+					return status.Error(codes.Unavailable, "station offline")
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+client.collectStream("/weather.WeatherService/StreamWeather", {}).then((messages) => {
+  call("messages: " + messages.length);
+}, (e) => {
+  call("error: " + e.status + " " + e.error);
+});
+`,
+			expectedCalls: []string{
+				`error: 14 station offline`,
+			},
+		},
+		{
+			name: "server streaming with keep-alive",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetStreamWeather(t, func(req *weatherpb.LocationRequest, stream weatherpb.WeatherService_StreamWeatherServer) error {
+					stream.Send(&weatherpb.WeatherResponse{})
+					time.Sleep(2 * time.Second)
+					stream.Send(&weatherpb.WeatherResponse{})
+					return nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+try {
+  client.connect("GRPC_WEB_ADDR", { keepAlive: { time: "500ms", timeout: "1s" } });
+  throw new Error("expected an error");
+} catch (e) {
+  if (!String(e).includes("keepAlive requires HTTP/2")) {
+    throw e;
+  }
+}
+client.connect("GRPC_WEB_ADDR", {
+  protocol: "grpc",
+  keepAlive: { time: "500ms", timeout: "1s" },
+});
+const stream = client.stream("/weather.WeatherService/StreamWeather", {});
+stream.on("data", (data) => {
+  call("data")
+});
+stream.on("error", (e) => {
+  call("error: " + e)
+});
+stream.on("end", () => {
+  call("end")
+  client.close();
+});
+`,
+			expectedCalls: []string{
+				`data`,
+				`data`,
+				`end`,
+			},
+		},
+		{
+			name: "connect with reflection cache",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{}, nil
+				})
+				reflectionsBefore = reflectionCalls.Load()
+			},
+			initCode: `
+let client = new grpcweb.Client();
+`,
+			code: `
+for (let i = 0; i < 3; i++) {
+  client.connect("GRPC_WEB_ADDR", {
+    reflect: true,
+  });
+  var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+  if (resp.status !== grpcweb.StatusOK) {
+    throw new Error("unexpected response status: " + resp.status);
+  }
+}
+`,
+			check: func(t *testing.T, _ <-chan metrics.SampleContainer) {
+				require.Equal(t, reflectionsBefore+1, reflectionCalls.Load())
+			},
+		},
+		{
+			name: "connect with reflection refresh",
+			setup: func(t *testing.T) {
+				reflectionsBefore = reflectionCalls.Load()
+			},
+			initCode: `
+let client = new grpcweb.Client();
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR", {
+  reflect: true,
+});
+client.connect("GRPC_WEB_ADDR", {
+  reflect: true,
+  refreshReflection: true,
+});
+`,
+			check: func(t *testing.T, _ <-chan metrics.SampleContainer) {
+				require.Equal(t, reflectionsBefore+2, reflectionCalls.Load())
+			},
+		},
+		{
+			name: "connect with reflection metadata",
+			initCode: `
+let client = new grpcweb.Client();
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR", {
+  reflect: true,
+  metadata: { "x-audience": "calls" },
+  reflectMetadata: { "x-audience": "reflection" },
+});
+`,
+			check: func(t *testing.T, _ <-chan metrics.SampleContainer) {
+				md, ok := reflectionMetadata.Load().(metadata.MD)
+				require.True(t, ok)
+				require.Equal(t, []string{"reflection"}, md.Get("x-audience"))
+			},
+		},
+		{
+			name: "invoke with content subtype",
+			server: func(t *testing.T) string {
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					contentType.Store(r.Header.Get("Content-Type"))
+					w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+					w.Header().Set("Grpc-Status", "12")
+					w.WriteHeader(http.StatusOK)
+				}))
+				t.Cleanup(server.Close)
+				return server.URL
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("SERVER_ADDR", { contentSubtype: "custom" });
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.status !== grpcweb.StatusUnimplemented) {
+  throw new Error("unexpected response status: " + resp.status + " " + resp.error);
+}
+`,
+			check: func(t *testing.T, _ <-chan metrics.SampleContainer) {
+				require.Equal(t, "application/grpc-web+custom", contentType.Load())
+			},
+		},
+		{
+			name: "invoke with authority",
+			server: func(t *testing.T) string {
+				hosts = make(chan string, 2)
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					hosts <- r.Host
+					w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+					w.Header().Set("Grpc-Status", "0")
+					w.WriteHeader(http.StatusOK)
+				}))
+				t.Cleanup(server.Close)
+				return server.URL
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("SERVER_ADDR");
+client.invoke("/weather.WeatherService/GetWeather", {}, { authority: "tenant-a.internal" });
+client.invoke("/weather.WeatherService/GetWeather", {});
+`,
+			check: func(t *testing.T, _ <-chan metrics.SampleContainer) {
+				require.Equal(t, "tenant-a.internal", <-hosts)
+				// the call without the parameter falls back to the host of connect
+				require.True(t, strings.HasPrefix(<-hosts, "127.0.0.1:"))
+			},
+		},
+		{
+			name: "invoke with http trace",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			vuState: func(state *lib.State) {
+				state.Options.SystemTags = metrics.NewSystemTagSet(metrics.TagService, metrics.TagMethod)
+			},
+			code: `
+client.connect("GRPC_WEB_ADDR");
+client.invoke("/weather.WeatherService/GetWeather", {}, { httpTrace: true, tags: { trace: "on" } });
+client.invoke("/weather.WeatherService/GetWeather", {}, { tags: { trace: "off" } });
+`,
+			check: func(t *testing.T, samples <-chan metrics.SampleContainer) {
+				traced := map[string][]string{}
+				for _, container := range metrics.GetBufferedSamples(samples) {
+					for _, sample := range container.GetSamples() {
+						switch sample.Metric.Name {
+						case "grpc_req_connecting", "grpc_req_tls_handshaking", "grpc_req_waiting":
+						default:
+							continue
+						}
+						service, _ := sample.Tags.Get("service")
+						require.Equal(t, "weather.WeatherService", service)
+						method, _ := sample.Tags.Get("method")
+						require.Equal(t, "GetWeather", method)
+						trace, _ := sample.Tags.Get("trace")
+						traced[sample.Metric.Name] = append(traced[sample.Metric.Name], trace)
+					}
+				}
+				// only the call with the option is traced
+				require.Equal(t, map[string][]string{
+					"grpc_req_connecting":      {"on"},
+					"grpc_req_tls_handshaking": {"on"},
+					"grpc_req_waiting":         {"on"},
+				}, traced)
+			},
+		},
+		{
+			name: "invoke and stream with message sizes",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{Temperature: 20, Status: "sunny"}, nil
+				})
+				weatherServiceServer.SetStreamWeather(t, func(req *weatherpb.LocationRequest, stream weatherpb.WeatherService_StreamWeatherServer) error {
+					if err := stream.Send(&weatherpb.WeatherResponse{Temperature: 20}); err != nil {
+						return err
+					}
+					return stream.Send(&weatherpb.WeatherResponse{Status: "cloudy"})
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			vuState: func(state *lib.State) {
+				state.Options.SystemTags = metrics.NewSystemTagSet(metrics.TagService, metrics.TagMethod)
+			},
+			code: `
+client.connect("GRPC_WEB_ADDR");
+client.invoke("/weather.WeatherService/GetWeather", { latitude: 35.6, longitude: 139.7 });
+const stream = client.stream("/weather.WeatherService/StreamWeather", { latitude: 35.6 });
+stream.on("end", () => {
+  client.close();
+});
+`,
+			check: func(t *testing.T, samples <-chan metrics.SampleContainer) {
+				sizes := map[string][]float64{}
+				for _, container := range metrics.GetBufferedSamples(samples) {
+					for _, sample := range container.GetSamples() {
+						if sample.Metric.Name != "grpc_req_bytes" && sample.Metric.Name != "grpc_resp_bytes" {
+							continue
+						}
+						service, _ := sample.Tags.Get("service")
+						require.Equal(t, "weather.WeatherService", service)
+						method, _ := sample.Tags.Get("method")
+						sizes[method+" "+sample.Metric.Name] = append(sizes[method+" "+sample.Metric.Name], sample.Value)
+					}
+				}
+				size := func(m proto.Message) float64 {
+					return float64(proto.Size(m))
+				}
+				require.Equal(t, map[string][]float64{
+					"GetWeather grpc_req_bytes":    {size(&weatherpb.LocationRequest{Latitude: 35.6, Longitude: 139.7})},
+					"GetWeather grpc_resp_bytes":   {size(&weatherpb.WeatherResponse{Temperature: 20, Status: "sunny"})},
+					"StreamWeather grpc_req_bytes": {size(&weatherpb.LocationRequest{Latitude: 35.6})},
+					"StreamWeather grpc_resp_bytes": {
+						size(&weatherpb.WeatherResponse{Temperature: 20}),
+						size(&weatherpb.WeatherResponse{Status: "cloudy"}),
+					},
+				}, sizes)
+			},
+		},
+		{
+			name: "invoke with unknown request field",
 			setup: func(t *testing.T) {
 				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
 					return &weatherpb.WeatherResponse{}, nil
@@ -36,14 +2762,130 @@ client.load([], "./internal/grpc/weather/weather_service.proto");
 `,
 			code: `
 client.connect("GRPC_WEB_ADDR");
-var resp = client.invoke("/weather.WeatherService/GetWeather", {});
-if (resp.status !== grpcweb.StatusOK) {
+try {
+  client.invoke("/weather.WeatherService/GetWeather", { city: "Tokyo" });
+} catch (e) {
+  // the prefix of protojson errors is not stable across builds
+  call("error: " + e.message.includes('unknown field "city"'));
+}
+var resp = client.invoke("/weather.WeatherService/GetWeather", { city: "Tokyo" }, { discardUnknownFields: true });
+call("status: " + resp.status);
+`,
+			expectedCalls: []string{
+				`error: true`,
+				`status: 0`,
+			},
+		},
+		{
+			name: "invoke with status code tag",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return nil, status.Error(codes.InvalidArgument, "invalid location")
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var resp = client.invoke("/weather.WeatherService/GetWeather", {}, { httpTrace: true });
+if (resp.status !== grpcweb.StatusInvalidArgument) {
   throw new Error("unexpected response status: " + resp.status);
 }
 `,
+			check: func(t *testing.T, samples <-chan metrics.SampleContainer) {
+				// every sample of the call is tagged with its status
+				statusCodes := map[string][]string{}
+				for _, container := range metrics.GetBufferedSamples(samples) {
+					for _, sample := range container.GetSamples() {
+						statusCode, _ := sample.Tags.Get("grpc_status_code")
+						statusCodes[sample.Metric.Name] = append(statusCodes[sample.Metric.Name], statusCode)
+					}
+				}
+				require.Equal(t, map[string][]string{
+					metrics.GRPCReqDurationName: {"3"},
+					"grpc_req_connecting":       {"3"},
+					"grpc_req_tls_handshaking":  {"3"},
+					"grpc_req_waiting":          {"3"},
+					"grpc_req_bytes":            {"3"},
+					"grpc_req_failed":           {"3"},
+				}, statusCodes)
+			},
 		},
 		{
-			name: "async invoke",
+			name: "server streaming with tags",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetStreamWeather(t, func(req *weatherpb.LocationRequest, stream weatherpb.WeatherService_StreamWeatherServer) error {
+					for range 2 {
+						stream.Send(&weatherpb.WeatherResponse{})
+					}
+					return nil
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+const stream = client.stream("/weather.WeatherService/StreamWeather", {}, { tags: { scenario_step: "forecast" } });
+stream.on("end", () => {
+  client.close();
+});
+`,
+			check: func(t *testing.T, samples <-chan metrics.SampleContainer) {
+				tagged := map[string]int{}
+				for _, container := range metrics.GetBufferedSamples(samples) {
+					for _, sample := range container.GetSamples() {
+						if v, ok := sample.Tags.Get("scenario_step"); ok && v == "forecast" {
+							tagged[sample.Metric.Name]++
+						}
+					}
+				}
+				require.Equal(t, 1, tagged["grpc_streams"])
+				require.Equal(t, 2, tagged["grpc_streams_msgs_received"])
+				// the duration of the stream is pushed when it ends
+				require.Equal(t, 1, tagged[metrics.GRPCReqDurationName])
+			},
+		},
+		{
+			name: "server streaming with connect tags",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetStreamWeather(t, func(req *weatherpb.LocationRequest, stream weatherpb.WeatherService_StreamWeatherServer) error {
+					return stream.Send(&weatherpb.WeatherResponse{})
+				})
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR", { tags: { region: "eu", tier: "gold" } });
+const stream = client.stream("/weather.WeatherService/StreamWeather", {}, { tags: { tier: "silver" } });
+stream.on("end", () => {
+  client.close();
+});
+`,
+			check: func(t *testing.T, samples <-chan metrics.SampleContainer) {
+				var found bool
+				for _, container := range metrics.GetBufferedSamples(samples) {
+					for _, sample := range container.GetSamples() {
+						if sample.Metric.Name != "grpc_streams" {
+							continue
+						}
+						found = true
+						region, _ := sample.Tags.Get("region")
+						require.Equal(t, "eu", region)
+						tier, _ := sample.Tags.Get("tier")
+						require.Equal(t, "silver", tier)
+					}
+				}
+				require.True(t, found)
+			},
+		},
+		{
+			name: "invoke with debug log",
 			setup: func(t *testing.T) {
 				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
 					return &weatherpb.WeatherResponse{}, nil
@@ -53,70 +2895,422 @@ if (resp.status !== grpcweb.StatusOK) {
 let client = new grpcweb.Client();
 client.load([], "./internal/grpc/weather/weather_service.proto");
 `,
+			vuState: func(state *lib.State) {
+				logger, hook := logtest.NewNullLogger()
+				logger.SetLevel(logrus.DebugLevel)
+				state.Logger = logger
+				logHook = hook
+			},
 			code: `
 client.connect("GRPC_WEB_ADDR");
-var resp = client.asyncInvoke("/weather.WeatherService/GetWeather", {}).then(function(resp) {
-  if (resp.status !== grpcweb.StatusOK) {
-    throw new Error("unexpected response status: " + resp.status);
+client.invoke("/weather.WeatherService/GetWeather", {}, { timeout: "10s" });
+`,
+			check: func(t *testing.T, _ <-chan metrics.SampleContainer) {
+				entry := logHook.LastEntry()
+				require.NotNil(t, entry)
+				require.Equal(t, logrus.DebugLevel, entry.Level)
+				require.Equal(t, "gRPC call finished", entry.Message)
+				require.Equal(t, "http://"+address+"/weather.WeatherService/GetWeather", entry.Data["url"])
+				require.Equal(t, "OK", entry.Data["status"])
+			},
+		},
+		{
+			name: "invoke with shared HTTP client",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			httpClient: &http.Client{Transport: transport},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("GRPC_WEB_ADDR");
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response status: " + resp.status + " " + resp.error);
+}
+`,
+			check: func(t *testing.T, _ <-chan metrics.SampleContainer) {
+				require.Equal(t, int64(1), transport.requests.Load())
+			},
+		},
+		{
+			name: "load protoset from URL",
+			server: func(t *testing.T) string {
+				protoset, err := os.ReadFile("./internal/grpc/weather/weather_service.protoset")
+				require.NoError(t, err)
+
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.Header.Get("Authorization") != "Bearer token" {
+						w.WriteHeader(http.StatusUnauthorized)
+						return
+					}
+					switch r.URL.Path {
+					case "/weather.protoset":
+						w.Header().Set("Content-Type", "application/octet-stream")
+						_, _ = w.Write(protoset)
+					case "/weather.html":
+						w.Header().Set("Content-Type", "text/html")
+						_, _ = w.Write([]byte("<html></html>"))
+					default:
+						w.WriteHeader(http.StatusNotFound)
+					}
+				}))
+				t.Cleanup(server.Close)
+				return server.URL
+			},
+			initCode: `
+let client = new grpcweb.Client();
+const headers = { Authorization: "Bearer token" };
+const methods = client.loadProtosetURL("SERVER_ADDR/weather.protoset", headers).map((m) => m.full_method);
+if (!methods.includes("/weather.WeatherService/GetWeather")) {
+  throw new Error("unexpected methods: " + JSON.stringify(methods));
+}
+const failures = [
+  ["SERVER_ADDR/missing.protoset", headers, "unexpected status 404"],
+  ["SERVER_ADDR/weather.protoset", {}, "unexpected status 401"],
+  ["SERVER_ADDR/weather.html", headers, "unexpected content type text/html"],
+];
+for (const [url, h, expected] of failures) {
+  try {
+    client.loadProtosetURL(url, h);
+    throw new Error("expected an error for " + url);
+  } catch (e) {
+    if (!String(e).includes(expected)) {
+      throw e;
+    }
   }
-}, (err) => {
-  throw new Error("unexpected error: " + err);
-});
+}
 `,
 		},
 		{
-			name: "invoke with reflection",
+			name: "load with import base",
+			// the file is resolved against the directory of the script instead of the working directory
+			scriptURL: scriptURL,
+			initCode: `
+const client = new grpcweb.Client({ importBase: "script" });
+client.load([], "optional_weather_service.proto");
+
+let failed = false;
+try {
+  new grpcweb.Client({ importBase: "cwd" }).load([], "optional_weather_service.proto");
+} catch (e) {
+  failed = true;
+}
+if (!failed) {
+  throw new Error("the file must not be found in the working directory");
+}
+`,
+		},
+		{
+			name: "invoke with forced TLS",
 			setup: func(t *testing.T) {
 				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
 					return &weatherpb.WeatherResponse{}, nil
 				})
 			},
+			server: func(t *testing.T) string {
+				server := grpc.NewServer()
+				weatherpb.RegisterWeatherServiceServer(server, weatherServiceServer)
+				reflection.Register(server)
+				return startTLSServer(t, server)
+			},
 			initCode: `
 let client = new grpcweb.Client();
 `,
 			code: `
-client.connect("GRPC_WEB_ADDR", {
+client.connect("SERVER_ADDR".replace("https://", "http://"), {
+  protocol: "grpc",
   reflect: true,
+  tls: { force: true, insecureSkipVerify: true },
 });
 var resp = client.invoke("/weather.WeatherService/GetWeather", {});
 if (resp.status !== grpcweb.StatusOK) {
-  throw new Error("unexpected response status: " + resp.status);
+  throw new Error("unexpected response: " + resp.status + " " + resp.error);
 }
 `,
 		},
 		{
-			name: "server streaming",
+			name: "invoke with grpc protocol over TLS",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			server: func(t *testing.T) string {
+				server := grpc.NewServer()
+				weatherpb.RegisterWeatherServiceServer(server, weatherServiceServer)
+				return startTLSServer(t, server)
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			vuState: func(state *lib.State) {
+				dialer.base = state.Dialer
+				state.Dialer = dialer
+			},
+			code: `
+client.connect("SERVER_ADDR", {
+  protocol: "grpc",
+  tls: { insecureSkipVerify: true },
+});
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response: " + resp.status + " " + resp.error);
+}
+`,
+			check: func(t *testing.T, _ <-chan metrics.SampleContainer) {
+				require.Equal(t, int64(1), dialer.dials.Load())
+			},
+		},
+		{
+			name: "invoke with mutual TLS",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{}, nil
+				})
+			},
+			server: func(t *testing.T) string {
+				server := grpc.NewServer()
+				weatherpb.RegisterWeatherServiceServer(server, weatherServiceServer)
+				return startMutualTLSServer(t, server)
+			},
+			// the files are read by the constructor in the init context
+			initCode: `
+let client = new grpcweb.Client({
+  tls: {
+    cert: "./testdata/tls/client.pem",
+    key: "./testdata/tls/client-key.pem",
+    cacerts: ["./testdata/tls/ca.pem"],
+    serverName: "localhost",
+  },
+});
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("SERVER_ADDR", { protocol: "grpc" });
+var resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response: " + resp.status + " " + resp.error);
+}
+
+// the files of connect are read through the init file system in the VU context
+client.connect("SERVER_ADDR", {
+  protocol: "grpc",
+  tls: {
+    cert: "./testdata/tls/client.pem",
+    key: "./testdata/tls/client-key.pem",
+    cacerts: "./testdata/tls/ca.pem",
+    serverName: "localhost",
+  },
+});
+resp = client.invoke("/weather.WeatherService/GetWeather", {});
+if (resp.status !== grpcweb.StatusOK) {
+  throw new Error("unexpected response: " + resp.status + " " + resp.error);
+}
+try {
+  client.connect("SERVER_ADDR", { protocol: "grpc", tls: { cacerts: "./testdata/tls/missing.pem" } });
+  throw new Error("expected an error");
+} catch (e) {
+  if (!String(e).includes("failed to read tls cacerts")) {
+    throw e;
+  }
+}
+`,
+		},
+		{
+			name: "invoke with package tag",
 			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{}, nil
+				})
 				weatherServiceServer.SetStreamWeather(t, func(req *weatherpb.LocationRequest, stream weatherpb.WeatherService_StreamWeatherServer) error {
-					for range 3 {
-						stream.Send(&weatherpb.WeatherResponse{})
-					}
-					return nil
+					return stream.Send(&weatherpb.WeatherResponse{})
 				})
 			},
 			initCode: `
 let client = new grpcweb.Client();
 client.load([], "./internal/grpc/weather/weather_service.proto");
 `,
+			vuState: func(state *lib.State) {
+				state.Options.SystemTags = metrics.NewSystemTagSet(metrics.TagService, metrics.TagMethod)
+			},
 			code: `
 client.connect("GRPC_WEB_ADDR");
+client.invoke("/weather.WeatherService/GetWeather", {});
 const stream = client.stream("/weather.WeatherService/StreamWeather", {});
-stream.on("data", (data) => {
-  call("data")
-});
-stream.on("error", (e) => {
-  call("error: " + e)
-});
 stream.on("end", () => {
-  call("end")
   client.close();
 });
+`,
+			check: func(t *testing.T, samples <-chan metrics.SampleContainer) {
+				tagged := map[string]int{}
+				for _, container := range metrics.GetBufferedSamples(samples) {
+					for _, sample := range container.GetSamples() {
+						if v, ok := sample.Tags.Get("grpc_package"); ok && v == "weather" {
+							tagged[sample.Metric.Name]++
+						}
+					}
+				}
+				require.Equal(t, 2, tagged["grpc_req_duration"])
+				require.Equal(t, 1, tagged["grpc_streams"])
+			},
+		},
+		{
+			name: "connect with reflection unavailable",
+			server: func(t *testing.T) string {
+				// the server does not register server reflection
+				server := grpc.NewServer()
+				weatherpb.RegisterWeatherServiceServer(server, weatherServiceServer)
+				return startTLSServer(t, server)
+			},
+			initCode: `
+let client = new grpcweb.Client();
+`,
+			code: `
+for (const [params, expected] of [
+  [{}, "neither grpc.reflection.v1 nor grpc.reflection.v1alpha is implemented; load the .proto files with load() instead"],
+  [{ reflectVersion: "v1" }, "grpc.reflection.v1 is not implemented; load the .proto files with load() instead"],
+]) {
+  try {
+    client.connect("SERVER_ADDR", Object.assign({ protocol: "grpc", tls: { insecureSkipVerify: true }, reflect: true }, params));
+    throw new Error("expected an error for " + JSON.stringify(params));
+  } catch (e) {
+    call(String(e).includes("server reflection is not available on SERVER_ADDR, " + expected) || String(e));
+  }
+}
+`,
+			expectedCalls: []string{"true", "true"},
+		},
+		{
+			name: "invoke with compression level",
+			setup: func(t *testing.T) {
+				weatherServiceServer.SetWeather(t, func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
+					return &weatherpb.WeatherResponse{Status: req.Time.AsTime().String()}, nil
+				})
+			},
+			server: func(t *testing.T) string {
+				server := grpc.NewServer()
+				weatherpb.RegisterWeatherServiceServer(server, weatherServiceServer)
+				return startTLSServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					grpcEncoding.Store(r.Header.Get("Grpc-Encoding"))
+					server.ServeHTTP(w, r)
+				}))
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.load([], "./internal/grpc/weather/weather_service.proto");
+`,
+			code: `
+client.connect("SERVER_ADDR", {
+  protocol: "grpc",
+  tls: { insecureSkipVerify: true },
+  compression: { algorithm: "gzip", level: 1 },
+});
+var resp = client.invoke("/weather.WeatherService/GetWeather", { time: "2023-01-01T00:00:00Z" });
+if (resp.status !== grpcweb.StatusOK || resp.message.status !== "2023-01-01 00:00:00 +0000 UTC") {
+  throw new Error("unexpected response: " + resp.status + " " + resp.error);
+}
+try {
+  client.connect("SERVER_ADDR", { compression: { algorithm: "gzip", level: 10 } });
+  throw new Error("invalid level must fail");
+} catch (e) {
+  if (!String(e).includes("compression level")) {
+    throw e;
+  }
+}
+`,
+			check: func(t *testing.T, _ <-chan metrics.SampleContainer) {
+				require.Equal(t, "gzip", grpcEncoding.Load())
+			},
+		},
+		{
+			name: "client streaming",
+			server: func(t *testing.T) string {
+				server := grpc.NewServer()
+				registerSumService(server)
+				return startTLSServer(t, server)
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.loadFromString("sum.proto", ` + "`" + sumProto + "`" + `);
+`,
+			code: `
+client.connect("SERVER_ADDR", {
+  protocol: "grpc",
+  tls: { insecureSkipVerify: true },
+});
+const stream = client.clientStream("/sum.SumService/Sum");
+stream.write({ value: 1 });
+stream.write({ value: 2 });
+stream.write({ value: 3 });
+stream.closeAndReceive().then((message) => {
+  call("sum: " + message.value);
+}, (e) => {
+  call("error: " + e.error);
+});
+try {
+  stream.write({ value: 4 });
+} catch (e) {
+  call("write after close: " + String(e).includes("after closeAndReceive"));
+}
 `,
 			expectedCalls: []string{
-				`data`,
-				`data`,
-				`data`,
-				`end`,
+				`write after close: true`,
+				`sum: 6`,
+			},
+		},
+		{
+			name: "client streaming with metadata provider and close",
+			server: func(t *testing.T) string {
+				server := grpc.NewServer()
+				registerSumService(server)
+				return startTLSServer(t, server)
+			},
+			initCode: `
+let client = new grpcweb.Client();
+client.loadFromString("sum.proto", ` + "`" + sumProto + "`" + `);
+`,
+			code: `
+client.connect("SERVER_ADDR", {
+  protocol: "grpc",
+  tls: { insecureSkipVerify: true },
+});
+client.setMetadataProvider(() => ({ "x-sum-offset": "10" }));
+(async () => {
+  const stream = client.clientStream("/sum.SumService/Sum");
+  stream.write({ value: 1 });
+  const message = await stream.closeAndReceive();
+  call("sum: " + message.value);
+
+  const canceled = client.clientStream("/sum.SumService/Sum");
+  canceled.write({ value: 1 });
+  client.close();
+  try {
+    await canceled.closeAndReceive();
+  } catch (e) {
+    call("error: " + e.status);
+  }
+})();
+`,
+			expectedCalls: []string{
+				`sum: 11`,
+				`error: 1`,
+			},
+			check: func(t *testing.T, samples <-chan metrics.SampleContainer) {
+				var failed []float64
+				for _, container := range metrics.GetBufferedSamples(samples) {
+					for _, sample := range container.GetSamples() {
+						if sample.Metric.Name == "grpc_req_failed" {
+							failed = append(failed, sample.Value)
+						}
+					}
+				}
+				require.Equal(t, []float64{0, 1}, failed)
 			},
 		},
 	} {
@@ -124,7 +3318,11 @@ stream.on("end", () => {
 			runtime, err := newRuntime(t)
 			require.NoError(t, err)
 
-			m, ok := new(xk6grpcweb.RootModule).NewModuleInstance(runtime.VU).(*xk6grpcweb.ModuleInstance)
+			root := new(xk6grpcweb.RootModule)
+			if tt.httpClient != nil {
+				root.SetHTTPClient(tt.httpClient)
+			}
+			m, ok := root.NewModuleInstance(runtime.VU).(*xk6grpcweb.ModuleInstance)
 			require.True(t, ok)
 			require.NoError(t, runtime.VU.Runtime().Set("grpcweb", m.Exports().Named))
 			recorder := &callRecorder{}
@@ -133,18 +3331,132 @@ stream.on("end", () => {
 			if tt.setup != nil {
 				tt.setup(t)
 			}
+			replacer := strings.NewReplacer(replacements...)
+			if tt.server != nil {
+				replacer = strings.NewReplacer(append(replacements, "SERVER_ADDR", tt.server(t))...)
+			}
 
 			// init phase
-			_, err = runtime.VU.Runtime().RunString(replacer.Replace(tt.initCode))
+			if tt.scriptURL != "" {
+				_, err = runtime.VU.Runtime().RunScript(tt.scriptURL, replacer.Replace(tt.initCode))
+			} else {
+				_, err = runtime.VU.Runtime().RunString(replacer.Replace(tt.initCode))
+			}
 			require.NoError(t, err)
 
-			moveToExecutionPhase(runtime)
+			samples := moveToExecutionPhase(runtime)
+			if tt.vuState != nil {
+				tt.vuState(runtime.VU.StateField)
+			}
 
 			// vu phase
 			_, err = runtime.RunOnEventLoop(replacer.Replace(tt.code))
 			require.NoError(t, err)
 
 			require.Equal(t, tt.expectedCalls, recorder.calls)
+			if tt.check != nil {
+				tt.check(t, samples)
+			}
 		})
 	}
 }
+
+type countingTransport struct {
+	requests atomic.Int64
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests.Add(1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// startMutualTLSServer serves the handler over TLS with the certificate for localhost in testdata/tls,
+// and requires client certificates issued by the CA in the same directory.
+func startMutualTLSServer(t *testing.T, handler http.Handler) string {
+	cert, err := tls.LoadX509KeyPair("./testdata/tls/server.pem", "./testdata/tls/server-key.pem")
+	require.NoError(t, err)
+	caPEM, err := os.ReadFile("./testdata/tls/ca.pem")
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(caPEM))
+
+	server := httptest.NewUnstartedServer(handler)
+	server.EnableHTTP2 = true
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// countingDialer counts the connections dialed through the dialer of the VU.
+type countingDialer struct {
+	base  lib.DialContexter
+	dials atomic.Int64
+}
+
+func (d *countingDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.dials.Add(1)
+	return d.base.DialContext(ctx, network, addr)
+}
+
+// startTLSServer serves the handler over TLS with HTTP/2 until the test ends, and returns its URL.
+func startTLSServer(t *testing.T, handler http.Handler) string {
+	server := httptest.NewUnstartedServer(handler)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// sumProto defines the client streaming service served by registerSumService.
+const sumProto = `
+syntax = "proto3";
+package sum;
+message Number {
+  int32 value = 1;
+}
+service SumService {
+  rpc Sum(stream Number) returns (Number);
+}
+`
+
+// registerSumService registers the service which responds with the sum of the received numbers and the x-sum-offset metadata.
+// It has no generated code, so Number is read as the wire compatible Int32Value.
+func registerSumService(server *grpc.Server) {
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "sum.SumService",
+		HandlerType: (*any)(nil),
+		Streams: []grpc.StreamDesc{
+			{
+				StreamName:    "Sum",
+				ClientStreams: true,
+				Handler: func(_ any, stream grpc.ServerStream) error {
+					var sum int32
+					if md, ok := metadata.FromIncomingContext(stream.Context()); ok {
+						for _, v := range md.Get("x-sum-offset") {
+							offset, err := strconv.Atoi(v)
+							if err != nil {
+								return err
+							}
+							sum += int32(offset)
+						}
+					}
+					for {
+						req := &wrapperspb.Int32Value{}
+						if err := stream.RecvMsg(req); errors.Is(err, io.EOF) {
+							break
+						} else if err != nil {
+							return err
+						}
+						sum += req.GetValue()
+					}
+					return stream.SendMsg(wrapperspb.Int32(sum))
+				},
+			},
+		},
+	}, struct{}{})
+}
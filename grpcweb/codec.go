@@ -1,8 +1,11 @@
 package grpcweb
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 
+	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -10,10 +13,24 @@ type deferredMessage struct {
 	data []byte
 }
 
-type protoCodec struct{}
+const (
+	codecNameProto = "proto"
+	codecNameJSON  = "json"
+)
+
+// protoCodec encodes messages in the Protobuf binary format.
+// The name is sent as the content subtype, which some gateways route on.
+type protoCodec struct {
+	name string
+	// deterministic orders map entries, which costs time with large maps.
+	deterministic bool
+}
 
 func (p protoCodec) Name() string {
-	return "proto"
+	if p.name == "" {
+		return codecNameProto
+	}
+	return p.name
 }
 
 func (p protoCodec) Marshal(a any) ([]byte, error) {
@@ -23,7 +40,7 @@ func (p protoCodec) Marshal(a any) ([]byte, error) {
 	}
 
 	options := proto.MarshalOptions{
-		Deterministic: true,
+		Deterministic: p.deterministic,
 	}
 	return options.Marshal(protoMessage)
 }
@@ -42,3 +59,53 @@ func (p protoCodec) Unmarshal(bytes []byte, a any) error {
 
 	return proto.Unmarshal(bytes, protoMessage)
 }
+
+// jsonCodec encodes messages in the Protobuf JSON format for gateways which accept the json content subtype.
+type jsonCodec struct{}
+
+func (j jsonCodec) Name() string {
+	return codecNameJSON
+}
+
+func (j jsonCodec) Marshal(a any) ([]byte, error) {
+	protoMessage, ok := a.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("cannot marshal: %T does not implement proto.Message", a)
+	}
+	return protojson.Marshal(protoMessage)
+}
+
+func (j jsonCodec) Unmarshal(bytes []byte, a any) error {
+	if deferred, ok := a.(*deferredMessage); ok {
+		// must make a copy since Connect framework will re-use the byte slice
+		deferred.data = make([]byte, len(bytes))
+		copy(deferred.data, bytes)
+		return nil
+	}
+	protoMessage, ok := a.(proto.Message)
+	if !ok {
+		return fmt.Errorf("cannot unmarshal: %T does not implement proto.Message", a)
+	}
+
+	// servers may know fields newer than the loaded descriptors, which the binary format skips as well
+	options := protojson.UnmarshalOptions{
+		DiscardUnknown: true,
+	}
+	return options.Unmarshal(bytes, protoMessage)
+}
+
+// validateContentSubtype checks the content subtype is a lower-case token which does not name another encoding.
+func validateContentSubtype(subtype string) error {
+	if subtype == "" {
+		return errors.New("contentSubtype must not be empty")
+	}
+	if subtype == codecNameJSON {
+		return fmt.Errorf("unsupported contentSubtype: %s, messages are encoded in the Protobuf binary format", subtype)
+	}
+	if strings.IndexFunc(subtype, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || strings.ContainsRune(".+-_", r))
+	}) >= 0 {
+		return fmt.Errorf("invalid contentSubtype: %s", subtype)
+	}
+	return nil
+}
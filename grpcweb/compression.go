@@ -0,0 +1,65 @@
+package grpcweb
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"connectrpc.com/connect"
+)
+
+// parseCompression returns the algorithm and the level of the compression param,
+// which is the name of the algorithm or an object with algorithm and level.
+func parseCompression(v any) (string, int, error) {
+	level := gzip.DefaultCompression
+
+	algorithm, ok := v.(string)
+	if !ok {
+		values, ok := v.(map[string]any)
+		if !ok {
+			return "", 0, errors.New("compression value must be string or object with algorithm and level")
+		}
+		for k, v := range values {
+			switch k {
+			case "algorithm":
+				algorithm, ok = v.(string)
+				if !ok {
+					return "", 0, errors.New("compression algorithm value must be string")
+				}
+			case "level":
+				n, ok := v.(int64)
+				if !ok || n < gzip.HuffmanOnly || n > gzip.BestCompression {
+					return "", 0, fmt.Errorf("compression level value must be an integer from %d to %d", gzip.HuffmanOnly, gzip.BestCompression)
+				}
+				level = int(n)
+			}
+		}
+	}
+
+	if algorithm != compressionGzip {
+		return "", 0, fmt.Errorf("unsupported compression: %s", algorithm)
+	}
+	return algorithm, level, nil
+}
+
+// compressionOptions returns the options compressing requests with gzip at the level.
+func compressionOptions(level int) []connect.ClientOption {
+	if level == gzip.DefaultCompression {
+		// connect registers gzip at the default level
+		return []connect.ClientOption{connect.WithSendCompression(compressionGzip)}
+	}
+	return []connect.ClientOption{
+		// remove the default gzip first, so that it is not advertised twice
+		connect.WithAcceptCompression(compressionGzip, nil, nil),
+		connect.WithAcceptCompression(compressionGzip,
+			func() connect.Decompressor { return &gzip.Reader{} },
+			func() connect.Compressor {
+				// the level is validated when parsed
+				w, _ := gzip.NewWriterLevel(io.Discard, level)
+				return w
+			},
+		),
+		connect.WithSendCompression(compressionGzip),
+	}
+}
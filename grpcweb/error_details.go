@@ -0,0 +1,75 @@
+package grpcweb
+
+import (
+	"encoding/json"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	// register google.rpc error detail types such as BadRequest to the global registry
+	_ "google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+type errorDetail struct {
+	Type  string
+	Value any
+}
+
+func (c *client) decodeErrorDetails(details []*connect.ErrorDetail) []errorDetail {
+	result := make([]errorDetail, 0, len(details))
+	for _, detail := range details {
+		result = append(result, errorDetail{
+			Type:  detail.Type(),
+			Value: c.decodeErrorDetail(detail),
+		})
+	}
+	return result
+}
+
+// decodeErrorDetail converts the error detail into JSON using the loaded descriptors or the global registry.
+// It returns nil if the message type of the detail is unknown.
+func (c *client) decodeErrorDetail(detail *connect.ErrorDetail) any {
+	var msg proto.Message
+	if md, ok := c.findMessageDescriptor(protoreflect.FullName(detail.Type())); ok {
+		dm := dynamicpb.NewMessage(md)
+		if err := proto.Unmarshal(detail.Bytes(), dm); err != nil {
+			return nil
+		}
+		msg = dm
+	} else {
+		v, err := detail.Value()
+		if err != nil {
+			return nil
+		}
+		msg = v
+	}
+
+	raw, err := protojson.Marshal(msg)
+	if err != nil {
+		return nil
+	}
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil
+	}
+	return value
+}
+
+// findMessageDescriptor finds the message in all loaded files, including the ones without services,
+// so that custom error detail types are decoded once their proto files are loaded.
+func (c *client) findMessageDescriptor(name protoreflect.FullName) (protoreflect.MessageDescriptor, bool) {
+	files := c.registry.Load()
+	if files == nil {
+		return nil, false
+	}
+
+	d, err := files.FindDescriptorByName(name)
+	if err != nil {
+		return nil, false
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	return md, ok
+}
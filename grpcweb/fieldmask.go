@@ -0,0 +1,52 @@
+package grpcweb
+
+import (
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// validateFieldMask checks the paths name the fields of the message following the FieldMask rules.
+func validateFieldMask(desc protoreflect.MessageDescriptor, paths []string) error {
+	_, err := fieldmaskpb.New(dynamicpb.NewMessage(desc), paths...)
+	return err
+}
+
+// maskJSON deletes the fields of the JSON object of the message which are not covered by the paths.
+// The object is masked after marshaling, so the fields of the paths are kept with their zero values when unpopulated
+// fields are emitted. The paths are validated beforehand, so the nested paths only go through singular message fields.
+func maskJSON(desc protoreflect.MessageDescriptor, obj map[string]any, paths []string, useProtoNames bool) {
+	keep := make(map[protoreflect.Name]bool)
+	nested := make(map[protoreflect.Name][]string)
+	for _, path := range paths {
+		name, rest, ok := strings.Cut(path, ".")
+		if !ok {
+			keep[protoreflect.Name(name)] = true
+			continue
+		}
+		nested[protoreflect.Name(name)] = append(nested[protoreflect.Name(name)], rest)
+	}
+
+	fields := desc.Fields()
+	for i := range fields.Len() {
+		fd := fields.Get(i)
+		key := fd.JSONName()
+		if useProtoNames {
+			key = fd.TextName()
+		}
+		v, ok := obj[key]
+		if !ok || keep[fd.Name()] {
+			continue
+		}
+		if rest := nested[fd.Name()]; len(rest) > 0 {
+			// well-known types and unset messages are not JSON objects, and are kept as they are
+			if nestedObj, ok := v.(map[string]any); ok {
+				maskJSON(fd.Message(), nestedObj, rest, useProtoNames)
+			}
+			continue
+		}
+		delete(obj, key)
+	}
+}
@@ -1,17 +1,32 @@
 package grpcweb
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/grafana/sobek"
 	"go.k6.io/k6/js/common"
 	"go.k6.io/k6/js/modules"
+	"go.k6.io/k6/lib/types"
 	"google.golang.org/grpc/codes"
 )
 
 var _ modules.Module = (*RootModule)(nil)
 
-type RootModule struct{}
+type RootModule struct {
+	httpClient *http.Client
+}
+
+// SetHTTPClient sets the HTTP client which clients connect with instead of building their own,
+// for programs embedding the module which configure tracing or connection pooling themselves.
+// The connect params configuring connections, such as tls and http2, don't apply to the calls made with it.
+// It must be called before the module instances are created.
+func (m *RootModule) SetHTTPClient(client *http.Client) {
+	m.httpClient = client
+}
 
 func (m *RootModule) NewModuleInstance(vu modules.VU) modules.Instance {
 	metrics, err := registerMetrics(vu.InitEnv().Registry)
@@ -19,10 +34,26 @@ func (m *RootModule) NewModuleInstance(vu modules.VU) modules.Instance {
 		common.Throw(vu.Runtime(), fmt.Errorf("failed to register gRPC Web module metrics: %w", err))
 	}
 
+	mi := &ModuleInstance{
+		vu:             vu,
+		metrics:        metrics,
+		defaultTimeout: defaultCallTimeout,
+	}
+
 	exports := make(map[string]any)
-	exports["Client"] = func(_ sobek.ConstructorCall) *sobek.Object {
+	exports["Client"] = func(call sobek.ConstructorCall) *sobek.Object {
 		rt := vu.Runtime()
-		return rt.ToValue(newClient(vu, metrics)).ToObject(rt)
+		c := newClient(vu, metrics)
+		c.sharedHTTPClient = m.httpClient
+		c.defaultTimeout = &mi.defaultTimeout
+
+		p, err := c.parseClientParams(call.Argument(0))
+		if err != nil {
+			common.Throw(rt, fmt.Errorf("invalid client params: %w", err))
+		}
+		c.params = p
+
+		return rt.ToValue(c).ToObject(rt)
 	}
 	rt := vu.Runtime()
 	exports["StatusOK"] = rt.ToValue(codes.OK)
@@ -42,12 +73,12 @@ func (m *RootModule) NewModuleInstance(vu modules.VU) modules.Instance {
 	exports["StatusUnavailable"] = rt.ToValue(codes.Unavailable)
 	exports["StatusDataLoss"] = rt.ToValue(codes.DataLoss)
 	exports["StatusUnauthenticated"] = rt.ToValue(codes.Unauthenticated)
+	exports["statusText"] = statusText
+	exports["statusCodes"] = statusCodes()
+	exports["setDefaultTimeout"] = mi.setDefaultTimeout
 
-	return &ModuleInstance{
-		vu:      vu,
-		exports: exports,
-		metrics: metrics,
-	}
+	mi.exports = exports
+	return mi
 }
 
 var _ modules.Instance = (*ModuleInstance)(nil)
@@ -57,6 +88,9 @@ type ModuleInstance struct {
 
 	exports map[string]any
 	metrics *instanceMetrics
+
+	// defaultTimeout is the timeout of unary calls of the clients without timeout parameters.
+	defaultTimeout time.Duration
 }
 
 func (i *ModuleInstance) Exports() modules.Exports {
@@ -64,3 +98,33 @@ func (i *ModuleInstance) Exports() modules.Exports {
 		Named: i.exports,
 	}
 }
+
+// statusCodes returns the status codes keyed by their canonical names.
+func statusCodes() map[string]codes.Code {
+	result := make(map[string]codes.Code)
+	for code := codes.OK; code <= codes.Unauthenticated; code++ {
+		result[code.String()] = code
+	}
+	return result
+}
+
+// setDefaultTimeout sets the timeout of unary calls of the clients without timeout parameters.
+func (i *ModuleInstance) setDefaultTimeout(v sobek.Value) error {
+	timeout, err := types.GetDurationValue(v.Export())
+	if err != nil {
+		return fmt.Errorf("invalid default timeout value: %w", err)
+	}
+	if timeout <= 0 {
+		return errors.New("default timeout value must be positive")
+	}
+	i.defaultTimeout = timeout
+	return nil
+}
+
+// statusText returns the canonical name of the status code, or the number itself if the code is unknown.
+func statusText(code int64) string {
+	if code < int64(codes.OK) || code > int64(codes.Unauthenticated) {
+		return strconv.FormatInt(code, 10)
+	}
+	return codes.Code(code).String()
+}
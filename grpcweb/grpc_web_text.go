@@ -0,0 +1,120 @@
+package grpcweb
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	contentTypeGRPCWeb     = "application/grpc-web"
+	contentTypeGRPCWebText = "application/grpc-web-text"
+)
+
+type grpcWebTextKey struct{}
+
+// withGRPCWebText returns a context that overrides whether requests sent with it use the text format.
+func withGRPCWebText(ctx context.Context, enabled bool) context.Context {
+	return context.WithValue(ctx, grpcWebTextKey{}, enabled)
+}
+
+// grpcWebTextTransport converts binary gRPC-Web requests and responses into the base64 encoded text format.
+// Connect framework only speaks the binary format, so the conversion is done at the HTTP layer.
+// The conversion is enabled by default or by the request context.
+type grpcWebTextTransport struct {
+	base    http.RoundTripper
+	enabled bool
+}
+
+func (t *grpcWebTextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	enabled := t.enabled
+	if v, ok := req.Context().Value(grpcWebTextKey{}).(bool); ok {
+		enabled = v
+	}
+
+	contentType := req.Header.Get("Content-Type")
+	if !enabled || !strings.HasPrefix(contentType, contentTypeGRPCWeb) {
+		return t.base.RoundTrip(req)
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	encoded := base64.StdEncoding.EncodeToString(body)
+
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(strings.NewReader(encoded))
+	req.ContentLength = int64(len(encoded))
+	req.Header.Set("Content-Type", strings.Replace(contentType, contentTypeGRPCWeb, contentTypeGRPCWebText, 1))
+	req.Header.Set("Accept", contentTypeGRPCWebText)
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if respContentType := resp.Header.Get("Content-Type"); strings.HasPrefix(respContentType, contentTypeGRPCWebText) {
+		resp.Header.Set("Content-Type", strings.Replace(respContentType, contentTypeGRPCWebText, contentTypeGRPCWeb, 1))
+		resp.Body = &grpcWebTextReader{src: resp.Body}
+		resp.ContentLength = -1
+	}
+	return resp, nil
+}
+
+func (t *grpcWebTextTransport) CloseIdleConnections() {
+	closeIdleConnections(t.base)
+}
+
+// grpcWebTextReader decodes a base64 encoded gRPC-Web response body.
+// Each 4-byte quantum is decoded separately since servers may pad every chunk they send.
+type grpcWebTextReader struct {
+	src     io.ReadCloser
+	encoded []byte
+	decoded []byte
+	err     error
+}
+
+func (r *grpcWebTextReader) Read(p []byte) (int, error) {
+	for len(r.decoded) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		var buf [4096]byte
+		n, err := r.src.Read(buf[:])
+		r.encoded = append(r.encoded, buf[:n]...)
+		r.err = err
+
+		for len(r.encoded) >= 4 {
+			var quantum [3]byte
+			m, err := base64.StdEncoding.Decode(quantum[:], r.encoded[:4])
+			if err != nil {
+				r.err = err
+				break
+			}
+			r.decoded = append(r.decoded, quantum[:m]...)
+			r.encoded = r.encoded[4:]
+		}
+
+		if errors.Is(r.err, io.EOF) && len(r.encoded) > 0 {
+			r.err = io.ErrUnexpectedEOF
+		}
+	}
+
+	n := copy(p, r.decoded)
+	r.decoded = r.decoded[n:]
+	return n, nil
+}
+
+func (r *grpcWebTextReader) Close() error {
+	return r.src.Close()
+}
@@ -0,0 +1,139 @@
+package grpcweb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"connectrpc.com/connect"
+	"go.k6.io/k6/lib/types"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const defaultHedgeMax = 2
+
+// errHedgeCanceled is the cause of the cancellation of the attempts which lost the race.
+var errHedgeCanceled = errors.New("canceled by another hedged attempt")
+
+type hedgeParams struct {
+	delay time.Duration
+	max   int
+}
+
+func parseHedgeParams(v any) (*hedgeParams, error) {
+	values, ok := v.(map[string]any)
+	if !ok {
+		return nil, errors.New("hedge must be an object with delay and max")
+	}
+
+	result := &hedgeParams{
+		max: defaultHedgeMax,
+	}
+	for k, v := range values {
+		switch k {
+		case "delay":
+			delay, err := types.GetDurationValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid hedge delay value: %w", err)
+			}
+			if delay <= 0 {
+				return nil, errors.New("hedge delay value must be positive")
+			}
+			result.delay = delay
+		case "max":
+			n, ok := v.(int64)
+			if !ok || n < 1 {
+				return nil, errors.New("hedge max value must be a positive integer")
+			}
+			result.max = int(n)
+		}
+	}
+	if result.delay == 0 {
+		return nil, errors.New("hedge delay is required")
+	}
+	return result, nil
+}
+
+type hedgeResult struct {
+	attempt int
+	p       *callParams
+	resp    *connect.Response[deferredMessage]
+	err     error
+}
+
+// callUnaryWithHedge calls the method, and sends another attempt each time the delay passes without a response,
+// up to max attempts in total. The first successful response wins and the other attempts are canceled.
+// Only idempotent methods are hedged.
+func (c *client) callUnaryWithHedge(ctx context.Context, client *connect.Client[dynamicpb.Message, deferredMessage], req *connect.Request[dynamicpb.Message], p *callParams) (*connect.Response[deferredMessage], error) {
+	if p.hedge == nil || !p.idempotent || p.hedge.max < 2 {
+		p.hedgeAttempt = 1
+		return c.callUnary(ctx, client, req, p)
+	}
+
+	results := make(chan hedgeResult, p.hedge.max)
+	cancels := make([]context.CancelCauseFunc, 0, p.hedge.max)
+	defer func() {
+		for _, cancel := range cancels {
+			cancel(errHedgeCanceled)
+		}
+	}()
+
+	launch := func() {
+		attemptCtx, cancel := context.WithCancelCause(ctx)
+		cancels = append(cancels, cancel)
+		attempt := len(cancels)
+
+		// each attempt records its own HTTP version and duration
+		attemptParams := *p
+		attemptReq := connect.NewRequest(req.Msg)
+		for k, v := range req.Header() {
+			attemptReq.Header()[k] = slices.Clone(v)
+		}
+		go func() {
+			resp, err := c.callUnary(attemptCtx, client, attemptReq, &attemptParams)
+			results <- hedgeResult{attempt: attempt, p: &attemptParams, resp: resp, err: err}
+		}()
+	}
+
+	launch()
+	pending := 1
+	timer := time.NewTimer(p.hedge.delay)
+	defer timer.Stop()
+
+	var last hedgeResult
+	for {
+		select {
+		case r := <-results:
+			pending--
+			last = r
+			if r.err == nil {
+				break
+			}
+			if pending > 0 {
+				continue
+			}
+			if len(cancels) == p.hedge.max || ctx.Err() != nil {
+				break
+			}
+			// all attempts failed, so the next one is sent without waiting for the delay
+			launch()
+			pending++
+			timer.Reset(p.hedge.delay)
+			continue
+		case <-timer.C:
+			if len(cancels) < p.hedge.max {
+				launch()
+				pending++
+				timer.Reset(p.hedge.delay)
+			}
+			continue
+		}
+
+		p.httpVersion = last.p.httpVersion
+		p.duration = last.p.duration
+		p.hedgeAttempt = last.attempt
+		return last.resp, last.err
+	}
+}
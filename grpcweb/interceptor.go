@@ -0,0 +1,157 @@
+package grpcweb
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/grafana/sobek"
+	"go.k6.io/k6/js/common"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// SetRequestInterceptor registers a function which is called with the method, the request headers as arrays of values
+// and the serialized request message before a call is made. The headers it returns replace the request headers unless unchanged.
+// The function runs on the VU event loop, so the message is serialized in advance the same way as it is sent.
+func (c *client) SetRequestInterceptor(fn sobek.Value) error {
+	if common.IsNullish(fn) {
+		c.requestInterceptor = nil
+		return nil
+	}
+
+	callable, ok := sobek.AssertFunction(fn)
+	if !ok {
+		return errors.New("request interceptor must be a function")
+	}
+	c.requestInterceptor = callable
+	return nil
+}
+
+// SetMetadataProvider registers a function which is called with the method before each call is made
+// and returns the metadata of the call, such as an auth token refreshed per iteration.
+// The metadata given to the call takes precedence over the returned one.
+func (c *client) SetMetadataProvider(fn sobek.Value) error {
+	if common.IsNullish(fn) {
+		c.metadataProvider = nil
+		return nil
+	}
+
+	callable, ok := sobek.AssertFunction(fn)
+	if !ok {
+		return errors.New("metadata provider must be a function")
+	}
+	c.metadataProvider = callable
+	return nil
+}
+
+// provideMetadata calls the metadata provider and returns the metadata it returns.
+func (c *client) provideMetadata(method string) (http.Header, error) {
+	header := http.Header{}
+	if c.metadataProvider == nil {
+		return header, nil
+	}
+
+	result, err := c.metadataProvider(sobek.Undefined(), c.vu.Runtime().ToValue(method))
+	if err != nil {
+		return nil, fmt.Errorf("metadata provider failed: %w", err)
+	}
+	if common.IsNullish(result) {
+		return header, nil
+	}
+
+	values, ok := result.Export().(map[string]any)
+	if !ok {
+		return nil, errors.New("metadata provider must return an object with key-value pairs")
+	}
+	for hk, hv := range values {
+		if err := appendMetadata(header, hk, hv); err != nil {
+			return nil, err
+		}
+	}
+	return header, nil
+}
+
+// interceptRequest calls the request interceptor and replaces the request headers with the returned ones.
+// The messages of client streams are sent later, so the interceptor receives an empty body for them.
+func (c *client) interceptRequest(method string, header http.Header, msg *dynamicpb.Message) error {
+	if c.requestInterceptor == nil {
+		return nil
+	}
+
+	var body []byte
+	if msg != nil {
+		var err error
+		if body, err = c.codec().Marshal(msg); err != nil {
+			return err
+		}
+	}
+
+	rt := c.vu.Runtime()
+	original := lowerCaseMetadata(header)
+	headers := rt.NewObject()
+	for k, values := range original {
+		items := make([]any, len(values))
+		for i, v := range values {
+			items[i] = v
+		}
+		if err := headers.Set(k, rt.NewArray(items...)); err != nil {
+			return err
+		}
+	}
+
+	result, err := c.requestInterceptor(sobek.Undefined(), rt.ToValue(method), headers, rt.ToValue(rt.NewArrayBuffer(body)))
+	if err != nil {
+		return fmt.Errorf("request interceptor failed: %w", err)
+	}
+	if common.IsNullish(result) {
+		return nil
+	}
+
+	values, ok := result.Export().(map[string]any)
+	if !ok {
+		return errors.New("request interceptor must return an object with key-value pairs")
+	}
+	intercepted := http.Header{}
+	for hk, hv := range values {
+		switch hv := hv.(type) {
+		case string:
+			intercepted[hk] = append(intercepted[hk], hv)
+		case []any:
+			for _, v := range hv {
+				value, ok := v.(string)
+				if !ok {
+					return fmt.Errorf("%s value must be string or array of strings", hk)
+				}
+				intercepted[hk] = append(intercepted[hk], value)
+			}
+		default:
+			return fmt.Errorf("%s value must be string or array of strings", hk)
+		}
+	}
+
+	// the headers are kept as they are if the interceptor returned them unchanged,
+	// since their keys are lower-cased for the interceptor
+	if maps.EqualFunc(lowerCaseMetadata(intercepted), original, slices.Equal) {
+		return nil
+	}
+	for k := range header {
+		delete(header, k)
+	}
+	for k, v := range intercepted {
+		header[k] = v
+	}
+	return nil
+}
+
+// lowerCaseMetadata returns the values of the header keyed by the lower-cased name, as gRPC metadata keys are.
+func lowerCaseMetadata(header http.Header) map[string][]string {
+	result := make(map[string][]string, len(header))
+	for k, v := range header {
+		key := strings.ToLower(k)
+		result[key] = append(result[key], v...)
+	}
+	return result
+}
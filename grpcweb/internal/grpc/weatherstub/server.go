@@ -2,6 +2,7 @@ package weatherstub
 
 import (
 	"context"
+	"sync"
 	"testing"
 
 	weatherpb "github.com/shota3506/xk6-grpc-web/grpcweb/internal/grpc/weather"
@@ -15,35 +16,51 @@ var _ weatherpb.WeatherServiceServer = (*WeatherServiceServer)(nil)
 type WeatherServiceServer struct {
 	weatherpb.UnimplementedWeatherServiceServer
 
+	// mu guards the functions, which are reset by test cleanups while calls timed out by the client may still be handled.
+	mu                sync.RWMutex
 	weatherFunc       func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error)
 	streamWeatherFunc func(req *weatherpb.LocationRequest, stream grpc.ServerStreamingServer[weatherpb.WeatherResponse]) error
 }
 
 func (s *WeatherServiceServer) GetWeather(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error) {
-	if s.weatherFunc != nil {
-		return s.weatherFunc(ctx, req)
+	s.mu.RLock()
+	f := s.weatherFunc
+	s.mu.RUnlock()
+	if f != nil {
+		return f(ctx, req)
 	}
 	return nil, status.Errorf(codes.Unimplemented, "method GetWeather not implemented")
 }
 
 func (s *WeatherServiceServer) StreamWeather(req *weatherpb.LocationRequest, stream grpc.ServerStreamingServer[weatherpb.WeatherResponse]) error {
-	if s.streamWeatherFunc != nil {
-		return s.streamWeatherFunc(req, stream)
+	s.mu.RLock()
+	f := s.streamWeatherFunc
+	s.mu.RUnlock()
+	if f != nil {
+		return f(req, stream)
 	}
 	return status.Errorf(codes.Unimplemented, "method StreamWeather not implemented")
 }
 
 func (s *WeatherServiceServer) SetWeather(t *testing.T, f func(ctx context.Context, req *weatherpb.LocationRequest) (*weatherpb.WeatherResponse, error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	prev := s.weatherFunc
 	t.Cleanup(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
 		s.weatherFunc = prev
 	})
 	s.weatherFunc = f
 }
 
 func (s *WeatherServiceServer) SetStreamWeather(t *testing.T, f func(req *weatherpb.LocationRequest, stream grpc.ServerStreamingServer[weatherpb.WeatherResponse]) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	prev := s.streamWeatherFunc
 	t.Cleanup(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
 		s.streamWeatherFunc = prev
 	})
 	s.streamWeatherFunc = f
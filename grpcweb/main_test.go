@@ -9,7 +9,9 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -21,7 +23,12 @@ import (
 	"go.k6.io/k6/lib/fsext"
 	"go.k6.io/k6/metrics"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 
 	weatherpb "github.com/shota3506/xk6-grpc-web/grpcweb/internal/grpc/weather"
 	"github.com/shota3506/xk6-grpc-web/grpcweb/internal/grpc/weatherstub"
@@ -31,6 +38,17 @@ var (
 	weatherServiceServer = &weatherstub.WeatherServiceServer{}
 	address              string
 
+	// grpcAddress is the address of the gRPC server without envoy.
+	grpcAddress string
+
+	// v1ReflectionAddress is the address of the gRPC server which only enables v1 server reflection.
+	v1ReflectionAddress string
+
+	// reflectionCalls counts the server reflection streams handled by the gRPC server.
+	reflectionCalls atomic.Int64
+	// reflectionMetadata holds the metadata of the last server reflection stream.
+	reflectionMetadata atomic.Value
+
 	noopLogger = &logrus.Logger{
 		Out:       io.Discard,
 		Formatter: new(logrus.TextFormatter),
@@ -41,7 +59,10 @@ var (
 )
 
 func TestMain(m *testing.M) {
-	const port = 50051
+	const (
+		port             = 50051
+		v1ReflectionPort = 50052
+	)
 
 	// start grpc server
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
@@ -49,7 +70,20 @@ func TestMain(m *testing.M) {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	server := grpc.NewServer()
+	// accept the application/grpc+json content type
+	encoding.RegisterCodec(jsonCodec{})
+
+	server := grpc.NewServer(
+		grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if strings.HasPrefix(info.FullMethod, "/grpc.reflection.") {
+				reflectionCalls.Add(1)
+				if md, ok := metadata.FromIncomingContext(ss.Context()); ok {
+					reflectionMetadata.Store(md)
+				}
+			}
+			return handler(srv, ss)
+		}),
+	)
 	weatherpb.RegisterWeatherServiceServer(server, weatherServiceServer)
 	reflection.Register(server)
 
@@ -61,6 +95,27 @@ func TestMain(m *testing.M) {
 		}
 	}()
 
+	// start grpc server which only enables v1 server reflection
+	v1ReflectionLis, err := net.Listen("tcp", fmt.Sprintf(":%d", v1ReflectionPort))
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+
+	v1ReflectionServer := grpc.NewServer()
+	weatherpb.RegisterWeatherServiceServer(v1ReflectionServer, weatherServiceServer)
+	reflection.RegisterV1(v1ReflectionServer)
+
+	go func() {
+		if err := v1ReflectionServer.Serve(v1ReflectionLis); err != nil {
+			if !errors.Is(err, grpc.ErrServerStopped) {
+				log.Fatalf("failed to serve: %v", err)
+			}
+		}
+	}()
+
+	grpcAddress = fmt.Sprintf("localhost:%d", port)
+	v1ReflectionAddress = fmt.Sprintf("localhost:%d", v1ReflectionPort)
+
 	// start envoy
 	pool, err := dockertest.NewPool("")
 	if err != nil {
@@ -114,6 +169,7 @@ func TestMain(m *testing.M) {
 	}
 
 	server.Stop()
+	v1ReflectionServer.Stop()
 
 	os.Exit(code)
 }
@@ -134,15 +190,18 @@ func newRuntime(t *testing.T) (*modulestest.Runtime, error) {
 	return runtime, nil
 }
 
-func moveToExecutionPhase(runtime *modulestest.Runtime) {
+// moveToExecutionPhase moves the runtime to the VU context, and returns the channel of the samples pushed by the VU.
+func moveToExecutionPhase(runtime *modulestest.Runtime) chan metrics.SampleContainer {
 	registry := metrics.NewRegistry()
+	samples := make(chan metrics.SampleContainer, 1e4)
 	runtime.MoveToVUContext(&lib.State{
-		Samples:        make(chan metrics.SampleContainer, 1e4),
+		Samples:        samples,
 		Dialer:         &net.Dialer{},
 		BuiltinMetrics: metrics.RegisterBuiltinMetrics(registry),
 		Tags:           lib.NewVUStateTags(registry.RootTagSet()),
 		Logger:         noopLogger,
 	})
+	return samples
 }
 
 type callRecorder struct {
@@ -155,3 +214,26 @@ func (r *callRecorder) call(text string) {
 	defer r.Unlock()
 	r.calls = append(r.calls, text)
 }
+
+// jsonCodec encodes messages of the gRPC server in the Protobuf JSON format.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("cannot marshal: %T does not implement proto.Message", v)
+	}
+	return protojson.Marshal(msg)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("cannot unmarshal: %T does not implement proto.Message", v)
+	}
+	return protojson.Unmarshal(data, msg)
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
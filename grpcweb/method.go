@@ -0,0 +1,118 @@
+package grpcweb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// maxMethodSuggestions is the maximum number of close matches listed when a method is not found.
+const maxMethodSuggestions = 3
+
+// normalizeMethod converts the method into the canonical /pkg.Service/Method form.
+// The leading slash may be omitted, and the method may be separated from the service with a dot.
+func normalizeMethod(method string) string {
+	method = strings.TrimPrefix(strings.TrimSpace(method), "/")
+	if !strings.Contains(method, "/") {
+		if i := strings.LastIndex(method, "."); i >= 0 {
+			method = method[:i] + "/" + method[i+1:]
+		}
+	}
+	return "/" + method
+}
+
+// lookupMethod returns the canonical name and the descriptor of the method.
+func (c *client) lookupMethod(method string) (string, protoreflect.MethodDescriptor, error) {
+	name := normalizeMethod(method)
+	if md, ok := c.mds[name]; ok {
+		return name, md, nil
+	}
+
+	if suggestions := c.closeMethods(name); len(suggestions) > 0 {
+		return "", nil, fmt.Errorf("method %s not found in file descriptors, did you mean %s?", method, strings.Join(suggestions, ", "))
+	}
+	return "", nil, fmt.Errorf("method %s not found in file descriptors", method)
+}
+
+const (
+	methodKindUnary        = "unary"
+	methodKindServerStream = "serverStream"
+	methodKindClientStream = "clientStream"
+	methodKindBidi         = "bidi"
+)
+
+// MethodKind returns whether the method is unary or which sides of it stream.
+func (c *client) MethodKind(method string) (string, error) {
+	_, md, err := c.lookupMethod(method)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case md.IsStreamingClient() && md.IsStreamingServer():
+		return methodKindBidi, nil
+	case md.IsStreamingClient():
+		return methodKindClientStream, nil
+	case md.IsStreamingServer():
+		return methodKindServerStream, nil
+	default:
+		return methodKindUnary, nil
+	}
+}
+
+// closeMethods returns the registered methods which have the same method name regardless of its case
+// or are within a few edits of the given name.
+func (c *client) closeMethods(name string) []string {
+	methodName := name[strings.LastIndex(name, "/")+1:]
+
+	type candidate struct {
+		name     string
+		distance int
+	}
+	var candidates []candidate
+	for registered := range c.mds {
+		distance := levenshtein(strings.ToLower(name), strings.ToLower(registered))
+		if distance > 3 && !strings.EqualFold(registered[strings.LastIndex(registered, "/")+1:], methodName) {
+			continue
+		}
+		candidates = append(candidates, candidate{name: registered, distance: distance})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].name < candidates[j].name
+	})
+
+	var suggestions []string
+	for _, candidate := range candidates {
+		if len(suggestions) == maxMethodSuggestions {
+			break
+		}
+		suggestions = append(suggestions, candidate.name)
+	}
+	return suggestions
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
@@ -1,15 +1,34 @@
 package grpcweb
 
-import "go.k6.io/k6/metrics"
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"go.k6.io/k6/metrics"
+	"google.golang.org/grpc/codes"
+)
 
 const (
 	gRPCStreamsName                 = "grpc_streams"
 	gRPCStreamsMessagesReceivedName = "grpc_streams_msgs_received"
+	gRPCReqConnectingName           = "grpc_req_connecting"
+	gRPCReqTLSHandshakingName       = "grpc_req_tls_handshaking"
+	gRPCReqWaitingName              = "grpc_req_waiting"
+	gRPCReqBytesName                = "grpc_req_bytes"
+	gRPCRespBytesName               = "grpc_resp_bytes"
+	gRPCReqFailedName               = "grpc_req_failed"
 )
 
 type instanceMetrics struct {
 	streams                 *metrics.Metric
 	streamsMessagesReceived *metrics.Metric
+	reqConnecting           *metrics.Metric
+	reqTLSHandshaking       *metrics.Metric
+	reqWaiting              *metrics.Metric
+	reqBytes                *metrics.Metric
+	respBytes               *metrics.Metric
+	reqFailed               *metrics.Metric
 }
 
 func registerMetrics(registry *metrics.Registry) (*instanceMetrics, error) {
@@ -23,8 +42,73 @@ func registerMetrics(registry *metrics.Registry) (*instanceMetrics, error) {
 		return nil, err
 	}
 
+	reqConnecting, err := registry.NewMetric(gRPCReqConnectingName, metrics.Trend, metrics.Time)
+	if err != nil {
+		return nil, err
+	}
+
+	reqTLSHandshaking, err := registry.NewMetric(gRPCReqTLSHandshakingName, metrics.Trend, metrics.Time)
+	if err != nil {
+		return nil, err
+	}
+
+	reqWaiting, err := registry.NewMetric(gRPCReqWaitingName, metrics.Trend, metrics.Time)
+	if err != nil {
+		return nil, err
+	}
+
+	reqBytes, err := registry.NewMetric(gRPCReqBytesName, metrics.Trend, metrics.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	respBytes, err := registry.NewMetric(gRPCRespBytesName, metrics.Trend, metrics.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	reqFailed, err := registry.NewMetric(gRPCReqFailedName, metrics.Rate)
+	if err != nil {
+		return nil, err
+	}
+
 	return &instanceMetrics{
 		streams:                 streams,
 		streamsMessagesReceived: streamsMessagesReceived,
+		reqConnecting:           reqConnecting,
+		reqTLSHandshaking:       reqTLSHandshaking,
+		reqWaiting:              reqWaiting,
+		reqBytes:                reqBytes,
+		respBytes:               respBytes,
+		reqFailed:               reqFailed,
 	}, nil
 }
+
+func pushMessageSize(ctx context.Context, samples chan<- metrics.SampleContainer, metric *metrics.Metric, ctm *metrics.TagsAndMeta, size int) {
+	metrics.PushIfNotDone(ctx, samples, metrics.Sample{
+		TimeSeries: metrics.TimeSeries{
+			Metric: metric,
+			Tags:   ctm.Tags,
+		},
+		Time:     time.Now(),
+		Metadata: ctm.Metadata,
+		Value:    float64(size),
+	})
+}
+
+// pushReqFailed records whether the call failed, tagged with its status code like grpc_req_duration.
+func pushReqFailed(ctx context.Context, samples chan<- metrics.SampleContainer, metric *metrics.Metric, ctm *metrics.TagsAndMeta, code codes.Code) {
+	failed := 0.0
+	if code != codes.OK {
+		failed = 1
+	}
+	metrics.PushIfNotDone(ctx, samples, metrics.Sample{
+		TimeSeries: metrics.TimeSeries{
+			Metric: metric,
+			Tags:   ctm.Tags.With("grpc_status_code", strconv.Itoa(int(code))),
+		},
+		Time:     time.Now(),
+		Metadata: ctm.Metadata,
+		Value:    failed,
+	})
+}
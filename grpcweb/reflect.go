@@ -0,0 +1,155 @@
+package grpcweb
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/grafana/sobek"
+	"go.k6.io/k6/js/common"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+type reflectParams struct {
+	address  string
+	metadata http.Header
+	protocol string
+	version  string
+}
+
+// Reflect loads the descriptors from the server reflection of the connected address, or the address in params,
+// and returns the methods of the reflected services. The TLS settings of the connection are used.
+func (c *client) Reflect(params sobek.Value) ([]methodInfo, error) {
+	if c.httpClient == nil {
+		return nil, errors.New("no gRPC Web connection, connect must be called first")
+	}
+
+	p, err := c.parseReflectParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := c.addr
+	if p.address != "" {
+		addr, err = url.Parse(p.address)
+		if err != nil {
+			return nil, err
+		}
+	}
+	setUserAgent(p.metadata, c.userAgent)
+
+	protocol := c.reflectProtocol
+	if p.protocol != "" {
+		protocol = p.protocol
+	}
+
+	fdset, err := c.reflectServer(c.vu.Context(), addr, p.metadata, c.tlsConfig, c.keepAlive, protocol, p.version, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.reflectionCache[addr.String()] = fdset
+
+	if _, err := c.registerMethods(fdset); err != nil {
+		return nil, err
+	}
+	return c.methodsOf(fdset), nil
+}
+
+// methodsOf returns the registered methods of the services defined in the files.
+func (c *client) methodsOf(fdset *descriptorpb.FileDescriptorSet) []methodInfo {
+	info := []methodInfo{}
+	for _, fd := range fdset.GetFile() {
+		for _, sd := range fd.GetService() {
+			service := sd.GetName()
+			if fd.GetPackage() != "" {
+				service = fd.GetPackage() + "." + service
+			}
+			for _, md := range sd.GetMethod() {
+				name := fmt.Sprintf("/%s/%s", service, md.GetName())
+				if md, ok := c.mds[name]; ok {
+					info = append(info, newMethodInfo(name, md))
+				}
+			}
+		}
+	}
+	sort.Slice(info, func(i, j int) bool {
+		return info[i].FullMethod < info[j].FullMethod
+	})
+	return info
+}
+
+func (c *client) parseReflectParams(params sobek.Value) (reflectParams, error) {
+	result := reflectParams{
+		metadata: http.Header{},
+	}
+
+	if common.IsNullish(params) {
+		return result, nil
+	}
+
+	rt := c.vu.Runtime()
+	paramsObject := params.ToObject(rt)
+
+	for _, k := range paramsObject.Keys() {
+		v := paramsObject.Get(k)
+
+		switch k {
+		case "address":
+			var ok bool
+			result.address, ok = v.Export().(string)
+			if !ok {
+				return reflectParams{}, errors.New("address value must be string")
+			}
+		case "metadata":
+			if common.IsNullish(v) {
+				break
+			}
+
+			metadata, ok := v.Export().(map[string]any)
+			if !ok {
+				return reflectParams{}, fmt.Errorf("metadata must be an object with key-value pairs")
+			}
+			for hk, hv := range metadata {
+				// TODO: support Binary-valued keys
+				if err := appendMetadata(result.metadata, hk, hv); err != nil {
+					return reflectParams{}, err
+				}
+			}
+		case "reflectVersion":
+			version, ok := v.Export().(string)
+			if !ok {
+				return reflectParams{}, errors.New("reflectVersion value must be string")
+			}
+			switch version {
+			case reflectVersionV1, reflectVersionV1Alpha:
+				result.version = version
+			default:
+				return reflectParams{}, fmt.Errorf("unsupported reflectVersion: %s", version)
+			}
+		case "reflectProtocol":
+			var err error
+			result.protocol, err = parseReflectProtocol(v)
+			if err != nil {
+				return reflectParams{}, err
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// parseReflectProtocol parses the protocol of the server reflection requests.
+func parseReflectProtocol(v sobek.Value) (string, error) {
+	protocol, ok := v.Export().(string)
+	if !ok {
+		return "", errors.New("reflectProtocol value must be string")
+	}
+	switch protocol {
+	case protocolGRPCWeb, protocolGRPC:
+		return protocol, nil
+	default:
+		return "", fmt.Errorf("unsupported reflectProtocol: %s", protocol)
+	}
+}
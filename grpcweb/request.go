@@ -0,0 +1,243 @@
+package grpcweb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/grafana/sobek"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// NewRequest returns a request object of the method with all fields present and zero-valued.
+func (c *client) NewRequest(method string) (any, error) {
+	_, md, err := c.lookupMethod(method)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := dynamicpb.NewMessage(md.Input())
+	populateMessages(msg, map[protoreflect.FullName]bool{})
+
+	raw, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the request into JSON: %w", err)
+	}
+
+	var req any
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the JSON request: %w", err)
+	}
+	return req, nil
+}
+
+// populateMessages sets empty messages to the singular message fields, so that they are marshaled as empty objects.
+// Fields of oneofs, well-known types and recursive messages are left unset.
+func populateMessages(msg protoreflect.Message, path map[protoreflect.FullName]bool) {
+	desc := msg.Descriptor()
+	path[desc.FullName()] = true
+	defer delete(path, desc.FullName())
+
+	fields := desc.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+			continue
+		}
+		if oneof := fd.ContainingOneof(); oneof != nil && !oneof.IsSynthetic() {
+			continue
+		}
+		if fd.Message().ParentFile().Package() == "google.protobuf" || path[fd.Message().FullName()] {
+			continue
+		}
+
+		field := msg.NewField(fd)
+		populateMessages(field.Message(), path)
+		msg.Set(fd, field)
+	}
+}
+
+// requestBytes returns the serialized request given as an ArrayBuffer or a Uint8Array.
+func requestBytes(req sobek.Value) ([]byte, bool) {
+	switch v := req.Export().(type) {
+	case sobek.ArrayBuffer:
+		return v.Bytes(), true
+	case []byte:
+		return v, true
+	default:
+		return nil, false
+	}
+}
+
+// validateRequest checks the JSON request against the message descriptor,
+// and returns an error naming the path of the first unknown, mistyped or missing required field.
+func validateRequest(desc protoreflect.MessageDescriptor, data []byte, discardUnknown bool) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return fmt.Errorf("invalid request: %w", err)
+	}
+
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return fmt.Errorf("invalid request: expected object, got %s", jsonTypeName(v))
+	}
+	return validateMessage(desc, obj, "", discardUnknown)
+}
+
+func validateMessage(desc protoreflect.MessageDescriptor, obj map[string]any, path string, discardUnknown bool) error {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fields := desc.Fields()
+	set := make(map[protoreflect.FieldNumber]bool, len(keys))
+	for _, k := range keys {
+		fieldPath := joinFieldPath(path, k)
+		fd := fields.ByJSONName(k)
+		if fd == nil {
+			fd = fields.ByName(protoreflect.Name(k))
+		}
+		if fd == nil {
+			if discardUnknown {
+				continue
+			}
+			return fmt.Errorf("unknown request field %s in %s", fieldPath, desc.FullName())
+		}
+
+		v := obj[k]
+		if v == nil {
+			continue
+		}
+		set[fd.Number()] = true
+
+		var err error
+		switch {
+		case fd.IsList():
+			err = validateList(fd, v, fieldPath, discardUnknown)
+		case fd.IsMap():
+			err = validateMap(fd, v, fieldPath, discardUnknown)
+		default:
+			err = validateValue(fd, v, fieldPath, discardUnknown)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.Cardinality() == protoreflect.Required && !set[fd.Number()] {
+			return fmt.Errorf("missing required request field %s", joinFieldPath(path, fd.JSONName()))
+		}
+	}
+	return nil
+}
+
+func validateList(fd protoreflect.FieldDescriptor, v any, path string, discardUnknown bool) error {
+	list, ok := v.([]any)
+	if !ok {
+		return fmt.Errorf("invalid request field %s: expected array, got %s", path, jsonTypeName(v))
+	}
+	for i, elem := range list {
+		if err := validateValue(fd, elem, fmt.Sprintf("%s[%d]", path, i), discardUnknown); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateMap(fd protoreflect.FieldDescriptor, v any, path string, discardUnknown bool) error {
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return fmt.Errorf("invalid request field %s: expected object, got %s", path, jsonTypeName(v))
+	}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if err := validateValue(fd.MapValue(), obj[k], fmt.Sprintf("%s[%q]", path, k), discardUnknown); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateValue(fd protoreflect.FieldDescriptor, v any, path string, discardUnknown bool) error {
+	expected := ""
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		// well-known types have their own JSON representations
+		if fd.Message().ParentFile().Package() == "google.protobuf" {
+			return nil
+		}
+		obj, ok := v.(map[string]any)
+		if !ok {
+			expected = "object"
+			break
+		}
+		return validateMessage(fd.Message(), obj, path, discardUnknown)
+	case protoreflect.EnumKind:
+		switch v := v.(type) {
+		case json.Number:
+			return nil
+		case string:
+			if fd.Enum().Values().ByName(protoreflect.Name(v)) == nil && !discardUnknown {
+				return fmt.Errorf("invalid request field %s: unknown value %q of enum %s", path, v, fd.Enum().FullName())
+			}
+			return nil
+		}
+		expected = "enum name or number"
+	case protoreflect.BoolKind:
+		if _, ok := v.(bool); ok {
+			return nil
+		}
+		expected = "boolean"
+	case protoreflect.StringKind, protoreflect.BytesKind:
+		if _, ok := v.(string); ok {
+			return nil
+		}
+		expected = "string"
+	default:
+		// numbers may also be quoted
+		switch v.(type) {
+		case json.Number, string:
+			return nil
+		}
+		expected = "number"
+	}
+	return fmt.Errorf("invalid request field %s: expected %s for %s, got %s", path, expected, fd.Kind(), jsonTypeName(v))
+}
+
+func joinFieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func jsonTypeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
@@ -0,0 +1,111 @@
+package grpcweb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"connectrpc.com/connect"
+	"go.k6.io/k6/lib/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const defaultRetryBackoff = 100 * time.Millisecond
+
+type retryParams struct {
+	max     int
+	backoff time.Duration
+	codes   map[connect.Code]struct{}
+}
+
+func parseRetryParams(v any) (*retryParams, error) {
+	values, ok := v.(map[string]any)
+	if !ok {
+		return nil, errors.New("retry must be an object with max, backoff and codes")
+	}
+
+	result := &retryParams{
+		backoff: defaultRetryBackoff,
+		codes: map[connect.Code]struct{}{
+			connect.CodeUnavailable: {},
+		},
+	}
+	for k, v := range values {
+		switch k {
+		case "max":
+			n, ok := v.(int64)
+			if !ok || n < 0 {
+				return nil, errors.New("retry max value must be a non-negative integer")
+			}
+			result.max = int(n)
+		case "backoff":
+			backoff, err := types.GetDurationValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid retry backoff value: %w", err)
+			}
+			result.backoff = backoff
+		case "codes":
+			names, ok := v.([]any)
+			if !ok {
+				return nil, errors.New("retry codes value must be an array of status names")
+			}
+			result.codes = make(map[connect.Code]struct{}, len(names))
+			for _, name := range names {
+				s, ok := name.(string)
+				if !ok {
+					return nil, errors.New("retry codes value must be an array of status names")
+				}
+				code, ok := parseStatusName(s)
+				if !ok {
+					return nil, fmt.Errorf("unknown status name: %s", s)
+				}
+				result.codes[connect.Code(code)] = struct{}{}
+			}
+		}
+	}
+	return result, nil
+}
+
+func (r *retryParams) shouldRetry(err error) bool {
+	if err == nil {
+		return false
+	}
+	_, ok := r.codes[connect.CodeOf(err)]
+	return ok
+}
+
+// parseStatusName returns the status code for the canonical name such as "Unavailable".
+func parseStatusName(name string) (codes.Code, bool) {
+	for code := codes.OK; code <= codes.Unauthenticated; code++ {
+		if code.String() == name {
+			return code, true
+		}
+	}
+	return 0, false
+}
+
+// callUnaryWithRetry calls the method and retries with exponential backoff while the call fails with a retryable status.
+// Retries are bounded by the deadline of the context, and only idempotent methods are retried.
+func (c *client) callUnaryWithRetry(ctx context.Context, client *connect.Client[dynamicpb.Message, deferredMessage], req *connect.Request[dynamicpb.Message], p *callParams) (*connect.Response[deferredMessage], error) {
+	resp, err := c.callUnaryWithHedge(ctx, client, req, p)
+	if p.retry == nil || !p.idempotent {
+		return resp, err
+	}
+
+	backoff := p.retry.backoff
+	for attempt := 0; attempt < p.retry.max && p.retry.shouldRetry(err); attempt++ {
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return resp, err
+		case <-timer.C:
+		}
+		backoff *= 2
+
+		resp, err = c.callUnaryWithHedge(ctx, client, req, p)
+	}
+	return resp, err
+}
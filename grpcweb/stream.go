@@ -4,21 +4,28 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math/rand/v2"
+	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"connectrpc.com/connect"
 	"github.com/grafana/sobek"
 	"github.com/mstoykov/k6-taskqueue-lib/taskqueue"
+	"github.com/sirupsen/logrus"
 	"go.k6.io/k6/js/common"
 	"go.k6.io/k6/js/modules"
 	"go.k6.io/k6/metrics"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/types/dynamicpb"
 )
 
 const (
+	eventTypeOpen  = "open"
 	eventTypeData  = "data"
 	eventTypeError = "error"
 	eventTypeEnd   = "end"
@@ -32,6 +39,7 @@ type eventListeners struct {
 func newEventListeners() *eventListeners {
 	return &eventListeners{
 		listeners: map[string][]func(sobek.Value) (sobek.Value, error){
+			eventTypeOpen:  {},
 			eventTypeData:  {},
 			eventTypeError: {},
 			eventTypeEnd:   {},
@@ -51,6 +59,13 @@ func (els *eventListeners) add(eventType string, fn func(sobek.Value) (sobek.Val
 	return nil
 }
 
+func (els *eventListeners) has(eventType string) bool {
+	els.mu.RLock()
+	defer els.mu.RUnlock()
+
+	return len(els.listeners[eventType]) > 0
+}
+
 func (els *eventListeners) all(eventType string) func(yield func(int, func(sobek.Value) (sobek.Value, error)) bool) {
 	return func(yield func(int, func(sobek.Value) (sobek.Value, error)) bool) {
 		els.mu.RLock()
@@ -71,12 +86,52 @@ type stream struct {
 
 	client         *connect.Client[dynamicpb.Message, deferredMessage]
 	md             protoreflect.MethodDescriptor
+	url            string
+	codec          connect.Codec
+	marshalOptions protojson.MarshalOptions
+	fieldMask      []string
+	decodeResponse bool
+	errorDetails   func([]*connect.ErrorDetail) []errorDetail
 	eventListeners *eventListeners
 	tq             *taskqueue.TaskQueue
 
+	// continueOnHandlerError logs errors of data event handlers instead of stopping the event delivery.
+	continueOnHandlerError bool
+	// maxMessages cancels the stream once the number of messages are received if positive.
+	maxMessages int
+	// consumeDelay and consumeJitter slow down receiving messages to simulate slow clients.
+	consumeDelay  time.Duration
+	consumeJitter time.Duration
+	// headersTimeout is how long stream waits for the response headers if positive.
+	headersTimeout time.Duration
+	// includeHeadersInData wraps the messages with the response headers.
+	includeHeadersInData bool
+
+	// responseHeader is set before opened is closed.
+	responseHeader http.Header
+	opened         chan struct{}
+
+	// Header and Headers are the response headers waited for by headersTimeout.
+	Header  map[string][]string
+	Headers map[string]string
+
+	// iterator and completion are only accessed on the event loop.
+	iterator   streamIterator
+	completion streamCompletion
+
 	stream *connect.ServerStreamForClient[deferredMessage]
 
 	cancel context.CancelFunc
+	// closed is set when the client is closed, which cancels the stream.
+	closed atomic.Bool
+	// release is called once the stream ends.
+	release func()
+}
+
+// close cancels the stream, which still emits the end event.
+func (s *stream) close() {
+	s.closed.Store(true)
+	s.cancel()
 }
 
 func (s *stream) On(eventType string, handler func(sobek.Value) (sobek.Value, error)) {
@@ -90,11 +145,18 @@ func (s *stream) On(eventType string, handler func(sobek.Value) (sobek.Value, er
 }
 
 func (s *stream) begin(ctx context.Context, req *connect.Request[dynamicpb.Message]) error {
+	logger := s.vu.State().Logger.WithFields(logrus.Fields{
+		"url":     s.url,
+		"timeout": remainingTimeout(ctx).String(),
+	})
+
+	beginTime := time.Now()
 	stream, err := s.client.CallServerStream(ctx, req)
 	if err != nil {
 		return err
 	}
 	s.stream = stream
+	logger.WithField("req_bytes", proto.Size(req.Msg)).Debug("gRPC stream started")
 
 	metrics.PushIfNotDone(s.vu.Context(), s.vu.State().Samples, metrics.Sample{
 		TimeSeries: metrics.TimeSeries{
@@ -105,26 +167,84 @@ func (s *stream) begin(ctx context.Context, req *connect.Request[dynamicpb.Messa
 		Metadata: s.tagsAndMeta.Metadata,
 		Value:    1,
 	})
+	pushMessageSize(s.vu.Context(), s.vu.State().Samples, s.metrics.reqBytes, s.tagsAndMeta, proto.Size(req.Msg))
 
 	// start goroutine to handle stream events
 	go func() {
 		defer s.tq.Close()
 		defer s.queueClose()
+		defer s.release()
+
+		// the headers are empty if no response was received
+		s.responseHeader = s.stream.ResponseHeader()
+		close(s.opened)
+		if len(s.responseHeader) > 0 {
+			s.queueOpen(s.responseHeader, time.Now())
+		}
+		var dataHeaders map[string]string
+		if s.includeHeadersInData {
+			dataHeaders = firstValues(binaryMetadata(s.responseHeader))
+		}
 
 		// read data
-		for s.stream.Receive() {
+		received, respBytes := 0, 0
+		for s.maxMessages <= 0 || received < s.maxMessages {
+			if received > 0 {
+				s.wait(ctx)
+			}
+			if !s.stream.Receive() {
+				break
+			}
+			received++
 			msg := s.stream.Msg()
+			respBytes += len(msg.data)
+			pushMessageSize(s.vu.Context(), s.vu.State().Samples, s.metrics.respBytes, s.tagsAndMeta, len(msg.data))
 
-			message, err := convertMessageToJSON(s.md, msg.data)
+			if !s.decodeResponse {
+				s.queueCallback(s.dataOf(nil, dataHeaders))
+				continue
+			}
+
+			message, err := convertMessageToJSON(s.md, msg.data, s.codec, s.marshalOptions, s.fieldMask)
 			if err != nil {
 				s.vu.State().Logger.Errorf("failed to unmarshal message: %v", err)
+				// notify the script of the dropped message
+				s.queueMessageError(connect.NewError(connect.CodeInternal, err))
 				continue
 			}
 
-			s.queueCallback(message)
+			s.queueCallback(s.dataOf(message, dataHeaders))
 		}
+		endTime := time.Now()
 
+		metrics.PushIfNotDone(s.vu.Context(), s.vu.State().Samples, metrics.Sample{
+			TimeSeries: metrics.TimeSeries{
+				Metric: s.vu.State().BuiltinMetrics.GRPCReqDuration,
+				Tags:   s.tagsAndMeta.Tags,
+			},
+			Time:     endTime,
+			Metadata: s.tagsAndMeta.Metadata,
+			Value:    metrics.D(endTime.Sub(beginTime)),
+		})
+		status := codes.OK
 		if err := s.stream.Err(); err != nil {
+			status = codes.Code(uint32(connect.CodeOf(err)))
+		}
+		logger.WithFields(logrus.Fields{
+			"duration":   endTime.Sub(beginTime).String(),
+			"status":     status.String(),
+			"messages":   received,
+			"resp_bytes": respBytes,
+		}).Debug("gRPC stream finished")
+
+		if s.closed.Load() {
+			// the stream was canceled by closing the client
+			_ = s.stream.Close()
+		} else if s.maxMessages > 0 && received >= s.maxMessages {
+			// the cancellation is expected, so it is not reported as an error
+			s.cancel()
+			_ = s.stream.Close()
+		} else if err := s.stream.Err(); err != nil {
 			var connectErr *connect.Error
 			if errors.As(err, &connectErr) {
 				s.queueError(connectErr)
@@ -138,6 +258,66 @@ func (s *stream) begin(ctx context.Context, req *connect.Request[dynamicpb.Messa
 	return nil
 }
 
+// wait sleeps for the consume delay before receiving the next message.
+// It returns early if the stream is canceled, which the next receive reports.
+func (s *stream) wait(ctx context.Context) {
+	if s.consumeDelay <= 0 && s.consumeJitter <= 0 {
+		return
+	}
+
+	delay := s.consumeDelay
+	if s.consumeJitter > 0 {
+		delay += rand.N(s.consumeJitter)
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+type streamOpen struct {
+	// Time is when the response headers were received, in milliseconds since the epoch.
+	Time    int64
+	Header  map[string][]string
+	Headers map[string]string
+}
+
+func (s *stream) queueOpen(header http.Header, t time.Time) {
+	header = binaryMetadata(header)
+	e := &streamOpen{
+		Time:    t.UnixMilli(),
+		Header:  header,
+		Headers: firstValues(header),
+	}
+	s.tq.Queue(func() (err error) {
+		rt := s.vu.Runtime()
+		s.eventListeners.all(eventTypeOpen)(func(_ int, f func(sobek.Value) (sobek.Value, error)) bool {
+			if _, err = f(rt.ToValue(e)); err != nil {
+				// quit the loop and return the error
+				return false
+			}
+			return true
+		})
+		return
+	})
+}
+
+type streamData struct {
+	Message any
+	Headers map[string]string
+}
+
+// dataOf returns the payload of the data event, which is the message itself unless includeHeadersInData is set.
+func (s *stream) dataOf(message any, headers map[string]string) any {
+	if !s.includeHeadersInData {
+		return message
+	}
+	return &streamData{Message: message, Headers: headers}
+}
+
 func (s *stream) queueCallback(message any) {
 	metrics.PushIfNotDone(s.vu.Context(), s.vu.State().Samples, metrics.Sample{
 		TimeSeries: metrics.TimeSeries{
@@ -153,37 +333,66 @@ func (s *stream) queueCallback(message any) {
 		rt := s.vu.Runtime()
 		s.eventListeners.all(eventTypeData)(func(i int, f func(sobek.Value) (sobek.Value, error)) bool {
 			if _, err = f(rt.ToValue(message)); err != nil {
+				if s.continueOnHandlerError {
+					s.vu.State().Logger.Errorf("data event handler failed: %v", err)
+					err = nil
+					return true
+				}
 				// quit the loop and return the error
 				return false
 			}
 			return true
 		})
+		s.iterator.push(message, s.eventListeners.has(eventTypeData))
 		return
 	})
 }
 
 type streamError struct {
 	Error        string
-	ErrorDetails []*connect.ErrorDetail
+	ErrorKind    string
+	ErrorDetails []errorDetail
 	Status       codes.Code
 }
 
+// queueError emits the error event for the error which ended the stream, and fails the iterator and done with it.
 func (s *stream) queueError(connectErr *connect.Error) {
-	s.tq.Queue(func() (err error) {
-		rt := s.vu.Runtime()
-		s.eventListeners.all(eventTypeError)(func(_ int, f func(sobek.Value) (sobek.Value, error)) bool {
-			if _, err = f(rt.ToValue(&streamError{
-				Error:        connectErr.Message(),
-				ErrorDetails: connectErr.Details(),
-				Status:       codes.Code(uint32(connectErr.Code())),
-			})); err != nil {
-				// quit the loop and return the error
-				return false
-			}
-			return true
-		})
-		return
+	s.tq.Queue(func() error {
+		e := s.newStreamError(connectErr)
+		err := s.emitError(e)
+		s.iterator.fail(e)
+		s.completion.fail(e)
+		return err
+	})
+}
+
+// queueMessageError emits the error event for a message which could not be decoded.
+// The stream goes on, so the iterator and done are left to the error or end of the stream.
+func (s *stream) queueMessageError(connectErr *connect.Error) {
+	s.tq.Queue(func() error {
+		return s.emitError(s.newStreamError(connectErr))
+	})
+}
+
+func (s *stream) newStreamError(connectErr *connect.Error) *streamError {
+	return &streamError{
+		Error:        connectErr.Message(),
+		ErrorKind:    errorKind(connectErr),
+		ErrorDetails: s.errorDetails(connectErr.Details()),
+		Status:       codes.Code(uint32(connectErr.Code())),
+	}
+}
+
+func (s *stream) emitError(e *streamError) (err error) {
+	rt := s.vu.Runtime()
+	s.eventListeners.all(eventTypeError)(func(_ int, f func(sobek.Value) (sobek.Value, error)) bool {
+		if _, err = f(rt.ToValue(e)); err != nil {
+			// quit the loop and return the error
+			return false
+		}
+		return true
 	})
+	return err
 }
 
 func (s *stream) queueClose() {
@@ -196,6 +405,8 @@ func (s *stream) queueClose() {
 			}
 			return true
 		})
+		s.iterator.end()
+		s.completion.end()
 		return
 	})
 
@@ -203,3 +414,131 @@ func (s *stream) queueClose() {
 		s.cancel()
 	}
 }
+
+// Next returns a promise of the next message following the async iterator protocol.
+// The promise resolves with done once the stream ends and rejects with the error of the stream.
+// Messages are buffered from the start of the stream unless data event listeners consume them before the first call.
+func (s *stream) Next() *sobek.Promise {
+	promise, resolve, reject := s.vu.Runtime().NewPromise()
+	s.iterator.next(func(v any) { resolve(v) }, func(v any) { reject(v) })
+	return promise
+}
+
+type iteratorResult struct {
+	Value any
+	Done  bool
+}
+
+type iteratorWaiter struct {
+	resolve func(any)
+	reject  func(any)
+}
+
+// streamIterator buffers the messages of a stream until they are requested by next.
+// Streams consumed by data event listeners only are not buffered, so that long streams do not pile up their messages.
+type streamIterator struct {
+	started  bool
+	messages []any
+	waiters  []iteratorWaiter
+	ended    bool
+	err      *streamError
+}
+
+func (it *streamIterator) next(resolve, reject func(any)) {
+	it.started = true
+	if len(it.messages) > 0 {
+		message := it.messages[0]
+		it.messages = it.messages[1:]
+		resolve(&iteratorResult{Value: message})
+		return
+	}
+	switch {
+	case it.err != nil:
+		reject(it.err)
+	case it.ended:
+		resolve(&iteratorResult{Done: true})
+	default:
+		it.waiters = append(it.waiters, iteratorWaiter{resolve: resolve, reject: reject})
+	}
+}
+
+func (it *streamIterator) push(message any, listened bool) {
+	if !it.started && listened {
+		return
+	}
+	if len(it.waiters) > 0 {
+		w := it.waiters[0]
+		it.waiters = it.waiters[1:]
+		w.resolve(&iteratorResult{Value: message})
+		return
+	}
+	it.messages = append(it.messages, message)
+}
+
+func (it *streamIterator) fail(err *streamError) {
+	it.err = err
+	for _, w := range it.waiters {
+		w.reject(err)
+	}
+	it.waiters = nil
+}
+
+func (it *streamIterator) end() {
+	it.ended = true
+	for _, w := range it.waiters {
+		if it.err != nil {
+			w.reject(it.err)
+			continue
+		}
+		w.resolve(&iteratorResult{Done: true})
+	}
+	it.waiters = nil
+}
+
+// Done returns a promise which resolves when the stream ends, or rejects with the error of the stream.
+func (s *stream) Done() *sobek.Promise {
+	promise, resolve, reject := s.vu.Runtime().NewPromise()
+	s.completion.wait(func(v any) { resolve(v) }, func(v any) { reject(v) })
+	return promise
+}
+
+// streamCompletion settles the promises returned by done once the stream fails or ends.
+type streamCompletion struct {
+	settled bool
+	err     *streamError
+	waiters []iteratorWaiter
+}
+
+func (c *streamCompletion) wait(resolve, reject func(any)) {
+	switch {
+	case c.err != nil:
+		reject(c.err)
+	case c.settled:
+		resolve(sobek.Undefined())
+	default:
+		c.waiters = append(c.waiters, iteratorWaiter{resolve: resolve, reject: reject})
+	}
+}
+
+func (c *streamCompletion) fail(err *streamError) {
+	if c.settled {
+		return
+	}
+	c.settled = true
+	c.err = err
+	for _, w := range c.waiters {
+		w.reject(err)
+	}
+	c.waiters = nil
+}
+
+func (c *streamCompletion) end() {
+	if c.settled {
+		return
+	}
+	c.settled = true
+	for _, w := range c.waiters {
+		w.resolve(sobek.Undefined())
+	}
+	c.waiters = nil
+}
@@ -0,0 +1,144 @@
+package grpcweb
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.k6.io/k6/js/common"
+	"go.k6.io/k6/lib/fsext"
+)
+
+type tlsParams struct {
+	cert               string
+	key                string
+	cacerts            []string
+	insecureSkipVerify bool
+	serverName         string
+	// force uses TLS even for addresses with the http scheme.
+	force bool
+}
+
+func parseTLSParams(v any) (*tlsParams, error) {
+	values, ok := v.(map[string]any)
+	if !ok {
+		return nil, errors.New("tls must be an object with cert, key, cacerts, insecureSkipVerify, serverName and force")
+	}
+
+	result := &tlsParams{}
+	for k, v := range values {
+		switch k {
+		case "cert":
+			result.cert, ok = v.(string)
+			if !ok {
+				return nil, errors.New("tls cert value must be string")
+			}
+		case "key":
+			result.key, ok = v.(string)
+			if !ok {
+				return nil, errors.New("tls key value must be string")
+			}
+		case "cacerts":
+			switch cacerts := v.(type) {
+			case string:
+				result.cacerts = []string{cacerts}
+			case []any:
+				for _, cacert := range cacerts {
+					value, ok := cacert.(string)
+					if !ok {
+						return nil, errors.New("tls cacerts value must be string or array of strings")
+					}
+					result.cacerts = append(result.cacerts, value)
+				}
+			default:
+				return nil, errors.New("tls cacerts value must be string or array of strings")
+			}
+		case "insecureSkipVerify":
+			result.insecureSkipVerify, ok = v.(bool)
+			if !ok {
+				return nil, errors.New("tls insecureSkipVerify value must be boolean")
+			}
+		case "serverName":
+			result.serverName, ok = v.(string)
+			if !ok {
+				return nil, errors.New("tls serverName value must be string")
+			}
+		case "force":
+			result.force, ok = v.(bool)
+			if !ok {
+				return nil, errors.New("tls force value must be boolean")
+			}
+		}
+	}
+
+	if (result.cert == "") != (result.key == "") {
+		return nil, errors.New("tls cert and key must be specified together")
+	}
+
+	return result, nil
+}
+
+// readTLSFiles replaces the file paths of cert, key and cacerts with the PEM contents of the files.
+// Files are read through the file system of the init context, so the files read in the init context are
+// bundled by k6 archive and cloud runs.
+func readTLSFiles(initEnv *common.InitEnvironment, p *tlsParams) error {
+	var err error
+	if p.cert, err = readPEM(initEnv, p.cert); err != nil {
+		return fmt.Errorf("failed to read tls cert: %w", err)
+	}
+	if p.key, err = readPEM(initEnv, p.key); err != nil {
+		return fmt.Errorf("failed to read tls key: %w", err)
+	}
+	for i, cacert := range p.cacerts {
+		if p.cacerts[i], err = readPEM(initEnv, cacert); err != nil {
+			return fmt.Errorf("failed to read tls cacerts: %w", err)
+		}
+	}
+	return nil
+}
+
+// buildTLSConfig builds the config from the params, whose cert, key and cacerts are PEM encoded by readTLSFiles.
+func buildTLSConfig(p *tlsParams) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: p.insecureSkipVerify,
+		ServerName:         p.serverName,
+	}
+
+	if p.cert != "" {
+		cert, err := tls.X509KeyPair([]byte(p.cert), []byte(p.key))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse tls key pair: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(p.cacerts) > 0 {
+		pool := x509.NewCertPool()
+		for _, cacert := range p.cacerts {
+			if !pool.AppendCertsFromPEM([]byte(cacert)) {
+				return nil, errors.New("failed to parse tls cacerts")
+			}
+		}
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
+
+// readPEM returns the value itself if it is PEM encoded or empty,
+// and reads the file of the path otherwise, which needs the client to be constructed in the init context.
+func readPEM(initEnv *common.InitEnvironment, value string) (string, error) {
+	if value == "" || strings.Contains(value, "-----BEGIN") {
+		return value, nil
+	}
+	if initEnv == nil {
+		return "", fmt.Errorf("%s is not PEM encoded, and files can only be read by clients constructed in the init context", value)
+	}
+	b, err := fsext.ReadFile(initEnv.FileSystems["file"], initEnv.GetAbsFilePath(value))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
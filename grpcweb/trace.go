@@ -0,0 +1,87 @@
+package grpcweb
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"go.k6.io/k6/metrics"
+)
+
+// tracer records the sub-phase timings of a single request.
+type tracer struct {
+	mu sync.Mutex
+
+	connectStart         time.Time
+	connectDone          time.Time
+	tlsHandshakeStart    time.Time
+	tlsHandshakeDone     time.Time
+	wroteRequest         time.Time
+	gotFirstResponseByte time.Time
+}
+
+func (t *tracer) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		ConnectStart: func(_, _ string) {
+			t.record(&t.connectStart)
+		},
+		ConnectDone: func(_, _ string, _ error) {
+			t.record(&t.connectDone)
+		},
+		TLSHandshakeStart: func() {
+			t.record(&t.tlsHandshakeStart)
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, _ error) {
+			t.record(&t.tlsHandshakeDone)
+		},
+		WroteRequest: func(_ httptrace.WroteRequestInfo) {
+			t.record(&t.wroteRequest)
+		},
+		GotFirstResponseByte: func() {
+			t.record(&t.gotFirstResponseByte)
+		},
+	}
+}
+
+func (t *tracer) record(field *time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	*field = time.Now()
+}
+
+func (t *tracer) push(ctx context.Context, samples chan<- metrics.SampleContainer, m *instanceMetrics, ctm *metrics.TagsAndMeta, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	metrics.PushIfNotDone(ctx, samples, metrics.Samples{
+		{
+			TimeSeries: metrics.TimeSeries{Metric: m.reqConnecting, Tags: ctm.Tags},
+			Time:       now,
+			Metadata:   ctm.Metadata,
+			Value:      metrics.D(elapsed(t.connectStart, t.connectDone)),
+		},
+		{
+			TimeSeries: metrics.TimeSeries{Metric: m.reqTLSHandshaking, Tags: ctm.Tags},
+			Time:       now,
+			Metadata:   ctm.Metadata,
+			Value:      metrics.D(elapsed(t.tlsHandshakeStart, t.tlsHandshakeDone)),
+		},
+		{
+			TimeSeries: metrics.TimeSeries{Metric: m.reqWaiting, Tags: ctm.Tags},
+			Time:       now,
+			Metadata:   ctm.Metadata,
+			Value:      metrics.D(elapsed(t.wroteRequest, t.gotFirstResponseByte)),
+		},
+	})
+}
+
+// elapsed returns the duration between start and end, or zero if either was not observed.
+func elapsed(start, end time.Time) time.Duration {
+	if start.IsZero() || end.IsZero() {
+		return 0
+	}
+	return end.Sub(start)
+}
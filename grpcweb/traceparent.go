@@ -0,0 +1,17 @@
+package grpcweb
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+// newTraceparent returns a W3C Trace Context traceparent header value of a new sampled trace.
+// The format is version-trace_id-parent_id-trace_flags, such as 00-<32 hex digits>-<16 hex digits>-01.
+func newTraceparent() (string, error) {
+	var ids [24]byte
+	if _, err := rand.Read(ids[:]); err != nil {
+		return "", fmt.Errorf("failed to generate trace ids: %w", err)
+	}
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(ids[:16]), hex.EncodeToString(ids[16:])), nil
+}
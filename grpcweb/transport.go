@@ -0,0 +1,265 @@
+package grpcweb
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"connectrpc.com/connect"
+	"go.k6.io/k6/lib/types"
+	"golang.org/x/net/http2"
+)
+
+type authorityKey struct{}
+
+// withAuthority returns a context that overrides the Host header of requests sent with it.
+func withAuthority(ctx context.Context, authority string) context.Context {
+	return context.WithValue(ctx, authorityKey{}, authority)
+}
+
+type httpVersionKey struct{}
+
+// withHTTPVersion returns a context that records the HTTP version of the responses to requests sent with it.
+func withHTTPVersion(ctx context.Context, version *string) context.Context {
+	return context.WithValue(ctx, httpVersionKey{}, version)
+}
+
+// authorityTransport sets the request Host from the authority stored in the request context,
+// and records the HTTP version of the response in the request context.
+type authorityTransport struct {
+	base http.RoundTripper
+}
+
+func (t *authorityTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if authority, ok := req.Context().Value(authorityKey{}).(string); ok {
+		req = req.Clone(req.Context())
+		req.Host = authority
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if version, ok := req.Context().Value(httpVersionKey{}).(*string); ok {
+		*version = resp.Proto
+	}
+	return resp, nil
+}
+
+func (t *authorityTransport) CloseIdleConnections() {
+	closeIdleConnections(t.base)
+}
+
+func closeIdleConnections(rt http.RoundTripper) {
+	if closer, ok := rt.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// newHTTP2Transport returns an HTTP2 transport, which uses h2c for addresses without TLS.
+// Connections are established by dial in both cases, so that the dialer of k6 and the dial timeout apply.
+func newHTTP2Transport(addr *url.URL, tlsConfig *tls.Config, dial func(ctx context.Context, network, addr string) (net.Conn, error)) *http2.Transport {
+	if addr.Scheme == "https" {
+		return &http2.Transport{
+			TLSClientConfig: tlsConfig,
+			DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				conn, err := dial(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+				tlsConn := tls.Client(conn, cfg)
+				if err := tlsConn.HandshakeContext(ctx); err != nil {
+					_ = conn.Close()
+					return nil, err
+				}
+				if p := tlsConn.ConnectionState().NegotiatedProtocol; p != http2.NextProtoTLS {
+					_ = tlsConn.Close()
+					return nil, fmt.Errorf("unexpected ALPN protocol %q, want %q", p, http2.NextProtoTLS)
+				}
+				return tlsConn, nil
+			},
+		}
+	}
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dial(ctx, network, addr)
+		},
+	}
+}
+
+// dialWithTimeout limits the time to establish a connection independently of the deadline of the call.
+func dialWithTimeout(dial func(ctx context.Context, network, addr string) (net.Conn, error), timeout time.Duration) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to dial %s within %s: %w", addr, timeout, err)
+		}
+		return conn, nil
+	}
+}
+
+type keepAliveParams struct {
+	time    time.Duration
+	timeout time.Duration
+}
+
+func parseKeepAliveParams(v any) (*keepAliveParams, error) {
+	values, ok := v.(map[string]any)
+	if !ok {
+		return nil, errors.New("keepAlive must be an object with time and timeout")
+	}
+
+	result := &keepAliveParams{}
+	for k, v := range values {
+		switch k {
+		case "time":
+			d, err := types.GetDurationValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid keepAlive time value: %w", err)
+			}
+			result.time = d
+		case "timeout":
+			d, err := types.GetDurationValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid keepAlive timeout value: %w", err)
+			}
+			result.timeout = d
+		}
+	}
+	return result, nil
+}
+
+// configure sets the health check by ping frames on the HTTP2 transport.
+func (k *keepAliveParams) configure(t *http2.Transport) {
+	if k == nil {
+		return
+	}
+	t.ReadIdleTimeout = k.time
+	t.PingTimeout = k.timeout
+}
+
+const modulePath = "github.com/shota3506/xk6-grpc-web"
+
+// defaultUserAgent returns the User-Agent identifying the extension and its version in the k6 binary.
+func defaultUserAgent() string {
+	version := "devel"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == modulePath {
+				version = dep.Version
+			}
+		}
+	}
+	return "xk6-grpc-web/" + version
+}
+
+// hasHeader reports whether the header contains the key regardless of its case.
+func hasHeader(header http.Header, key string) bool {
+	for k := range header {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// setUserAgent sets the User-Agent of the header unless it has one in any case, which is moved to the canonical key
+// because connect replaces the User-Agent of requests without it.
+func setUserAgent(header http.Header, userAgent string) {
+	for k, v := range header {
+		if strings.EqualFold(k, "User-Agent") {
+			delete(header, k)
+			header["User-Agent"] = v
+			return
+		}
+	}
+	if userAgent != "" {
+		header.Set("User-Agent", userAgent)
+	}
+}
+
+// appendMetadata appends the metadata value, which is a string or an array of strings, to the header.
+func appendMetadata(header http.Header, key string, value any) error {
+	switch value := value.(type) {
+	case string:
+		header[key] = append(header[key], value)
+	case []any:
+		for _, v := range value {
+			s, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("%s values must be strings", key)
+			}
+			header[key] = append(header[key], s)
+		}
+	default:
+		return fmt.Errorf("%s value must be string or array of strings", key)
+	}
+	return nil
+}
+
+// binaryMetadata returns the header with the values of binary keys, which end with -bin,
+// encoded in padded base64 regardless of the padding sent by the server.
+// Values which are not base64 are kept as they are.
+// The result is a plain map, since http.Header is exposed to scripts without its keys because of its methods.
+func binaryMetadata(header http.Header) map[string][]string {
+	result := map[string][]string(header.Clone())
+	for k, values := range result {
+		if !strings.HasSuffix(strings.ToLower(k), "-bin") {
+			continue
+		}
+		for i, v := range values {
+			if decoded, err := connect.DecodeBinaryHeader(v); err == nil {
+				values[i] = base64.StdEncoding.EncodeToString(decoded)
+			}
+		}
+	}
+	return result
+}
+
+// firstValues flattens the header into a map of lower-cased keys to their first values.
+func firstValues(header http.Header) map[string]string {
+	values := make(map[string]string, len(header))
+	for k, v := range header {
+		if len(v) > 0 {
+			values[strings.ToLower(k)] = v[0]
+		}
+	}
+	return values
+}
+
+// reflectionVersionTransport pins the server reflection requests to a single version of the service.
+// The grpcreflect client always tries v1 first and falls back to v1alpha, so the path is rewritten instead.
+// Both versions share the same messages on the wire.
+type reflectionVersionTransport struct {
+	base    http.RoundTripper
+	version string
+}
+
+func (t *reflectionVersionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := "/grpc.reflection." + t.version + ".ServerReflection/"
+	for _, version := range []string{reflectVersionV1, reflectVersionV1Alpha} {
+		prefix := "/grpc.reflection." + version + ".ServerReflection/"
+		if version != t.version && strings.Contains(req.URL.Path, prefix) {
+			req = req.Clone(req.Context())
+			req.URL.Path = strings.Replace(req.URL.Path, prefix, path, 1)
+			req.URL.RawPath = ""
+			break
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+func (t *reflectionVersionTransport) CloseIdleConnections() {
+	closeIdleConnections(t.base)
+}
@@ -0,0 +1,42 @@
+package grpcweb
+
+import (
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	// register the descriptors of well-known types to the global registry
+	_ "google.golang.org/protobuf/types/known/anypb"
+	_ "google.golang.org/protobuf/types/known/durationpb"
+	_ "google.golang.org/protobuf/types/known/emptypb"
+	_ "google.golang.org/protobuf/types/known/fieldmaskpb"
+	_ "google.golang.org/protobuf/types/known/structpb"
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+	_ "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// addWellKnownTypes adds the descriptors of well-known types which are imported but not included in the set,
+// e.g. a protoset compiled without its imports.
+func addWellKnownTypes(fdset *descriptorpb.FileDescriptorSet) {
+	included := make(map[string]struct{}, len(fdset.File))
+	for _, fd := range fdset.File {
+		included[fd.GetName()] = struct{}{}
+	}
+
+	// files appended in the loop are also visited to add their own imports
+	for i := 0; i < len(fdset.File); i++ {
+		for _, dep := range fdset.File[i].GetDependency() {
+			if _, ok := included[dep]; ok || !strings.HasPrefix(dep, "google/protobuf/") {
+				continue
+			}
+			fd, err := protoregistry.GlobalFiles.FindFileByPath(dep)
+			if err != nil {
+				continue
+			}
+			included[dep] = struct{}{}
+			fdset.File = append(fdset.File, protodesc.ToFileDescriptorProto(fd))
+		}
+	}
+}